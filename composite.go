@@ -0,0 +1,144 @@
+package main
+
+import "log"
+
+type compositeOptions struct {
+	Rule    string             `yaml:"rule"`
+	Min     int                `yaml:"min"`
+	Percent float64            `yaml:"percent"`
+	Weights map[string]float64 `yaml:"weights"`
+}
+
+// Summarise the states of one service's URLs
+func serviceState(states []string) string {
+	online, up := 0, 0
+	for _, st := range states {
+		switch st {
+		case "online":
+			online++
+			up++
+		case "degraded":
+			up++
+		}
+	}
+
+	switch {
+	case len(states) > 0 && online == len(states):
+		return "online"
+	case up > 0:
+		return "degraded"
+	}
+	return "offline"
+}
+
+// Compute a composite service from the services its URLs name, whose URLs
+// are its members.  Each URL shows the named service's state with its
+// members as children, and the composite's own state is returned.
+// Composites can build on composites listed above them, which count as
+// one member.  Weights, by service name, only apply to the percent rule.
+func (s *server) compose(c *Config) string {
+	opts := &compositeOptions{}
+	if err := (Service{Options: c.Options}).decodeOptions(opts); err != nil {
+		log.Println("composite", c.Name, "options:", err)
+		return "offline"
+	}
+
+	var members, online, up, pending int
+	var weight, weightOnline, weightUp, weightPending float64
+
+	for i, name := range c.URL {
+		c.state[i] = "unknown"
+		c.children[i] = nil
+
+		var ref *Config
+		for _, other := range s.cfg {
+			if other.Name == name && other != c {
+				ref = other
+			}
+		}
+		if ref == nil {
+			log.Println("composite", c.Name, "unknown service", name)
+			continue
+		}
+
+		w, ok := opts.Weights[name]
+		if !ok {
+			w = 1
+		}
+
+		states := ref.state
+		if ref.Type == "composite" {
+			states = []string{ref.composite}
+		}
+
+		children := make([]*URL, 0, len(ref.URL))
+		for j, u := range ref.URL {
+			children = append(children, &URL{Name: u, State: ref.state[j], URL: u, Children: ref.children[j]})
+		}
+
+		for _, st := range states {
+			members++
+			weight += w
+			switch st {
+			case "online":
+				online++
+				up++
+				weightOnline += w
+				weightUp += w
+			case "degraded":
+				up++
+				weightUp += w
+			case "offline":
+			default:
+				pending++
+				weightPending += w
+			}
+		}
+
+		c.state[i] = serviceState(states)
+		c.children[i] = children
+	}
+
+	if members == 0 {
+		return "offline"
+	}
+
+	// online when enough members are online, degraded when enough are at
+	// least degraded, and unknown while members yet to be checked could
+	// still make it so
+	on, atLeast, unsure, need := float64(online), float64(up), float64(pending), float64(members)
+
+	switch opts.Rule {
+	case "", "all":
+	case "any":
+		need = 1
+	case "at_least":
+		if opts.Min < 1 || opts.Min > members {
+			log.Println("composite", c.Name, "min must be between 1 and", members)
+			return "offline"
+		}
+		need = float64(opts.Min)
+	case "percent":
+		if opts.Percent <= 0 || opts.Percent > 100 {
+			log.Println("composite", c.Name, "percent must be between 0 and 100")
+			return "offline"
+		}
+		on, atLeast, unsure, need = weightOnline, weightUp, weightPending, weight*opts.Percent/100
+	default:
+		log.Println("composite", c.Name, "unknown rule", opts.Rule)
+		return "offline"
+	}
+
+	switch {
+	case on >= need:
+		return "online"
+	case unsure > 0 && on+unsure >= need:
+		return "unknown"
+	case atLeast >= need:
+		return "degraded"
+	case unsure > 0 && atLeast+unsure >= need:
+		return "unknown"
+	}
+
+	return "offline"
+}
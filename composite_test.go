@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A server with three frontends, a database and a composite over them
+func testComposite(frontends []string, db string, options map[string]interface{}) *server {
+	cfg := []*Config{
+		{
+			Name:     "frontends",
+			Type:     "website",
+			URL:      []string{"http://fe1", "http://fe2", "http://fe3"},
+			state:    frontends,
+			children: make([][]*URL, 3),
+		},
+		{
+			Name:     "db",
+			Type:     "website",
+			URL:      []string{"http://db"},
+			state:    []string{db},
+			children: make([][]*URL, 1),
+		},
+		{
+			Name:     "web tier",
+			Type:     "composite",
+			URL:      []string{"frontends", "db"},
+			Options:  options,
+			state:    make([]string, 2),
+			children: make([][]*URL, 2),
+		},
+	}
+
+	return &server{cfg: cfg}
+}
+
+func TestCompositeRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		frontends []string
+		db        string
+		options   map[string]interface{}
+		state     string
+	}{
+		{"all online", []string{"online", "online", "online"}, "online", nil, "online"},
+		{"all degraded", []string{"online", "degraded", "online"}, "online", nil, "degraded"},
+		{"all offline", []string{"online", "offline", "online"}, "online", map[string]interface{}{"rule": "all"}, "offline"},
+		{"any", []string{"offline", "offline", "offline"}, "online", map[string]interface{}{"rule": "any"}, "online"},
+		{"any degraded", []string{"offline", "offline", "degraded"}, "offline", map[string]interface{}{"rule": "any"}, "degraded"},
+		{"any offline", []string{"offline", "offline", "offline"}, "offline", map[string]interface{}{"rule": "any"}, "offline"},
+		{"at least", []string{"online", "offline", "online"}, "online", map[string]interface{}{"rule": "at_least", "min": 3}, "online"},
+		{"at least degraded", []string{"degraded", "offline", "online"}, "online", map[string]interface{}{"rule": "at_least", "min": 3}, "degraded"},
+		{"at least offline", []string{"offline", "offline", "online"}, "online", map[string]interface{}{"rule": "at_least", "min": 3}, "offline"},
+		{"at least too many", []string{"online", "online", "online"}, "online", map[string]interface{}{"rule": "at_least", "min": 5}, "offline"},
+		{"percent", []string{"offline", "online", "online"}, "online", map[string]interface{}{"rule": "percent", "percent": 80, "weights": map[string]interface{}{"db": 7}}, "online"},
+		{"percent short", []string{"offline", "offline", "online"}, "online", map[string]interface{}{"rule": "percent", "percent": 90, "weights": map[string]interface{}{"db": 7}}, "offline"},
+		{"unknown rule", []string{"online", "online", "online"}, "online", map[string]interface{}{"rule": "most"}, "offline"},
+		{"pending", []string{"unknown", "online", "online"}, "online", nil, "unknown"},
+		{"pending decided", []string{"unknown", "offline", "offline"}, "offline", map[string]interface{}{"rule": "at_least", "min": 2}, "offline"},
+		{"pending degraded", []string{"unknown", "degraded", "degraded"}, "online", map[string]interface{}{"rule": "at_least", "min": 3}, "degraded"},
+	}
+
+	for _, tt := range tests {
+		s := testComposite(tt.frontends, tt.db, tt.options)
+		s.processSites()
+
+		if state := s.sites.Sites[2].State; state != tt.state {
+			t.Errorf("%s: state = %q, expected %q", tt.name, state, tt.state)
+		}
+	}
+}
+
+func TestCompositeTree(t *testing.T) {
+	s := testComposite([]string{"online", "offline", "online"}, "online", map[string]interface{}{"rule": "at_least", "min": 3})
+	s.processSites()
+
+	site := s.sites.Sites[2]
+	if site.State != "online" || s.sites.Sites[0].State != "" {
+		t.Errorf("site states = %q, %q", site.State, s.sites.Sites[0].State)
+	}
+
+	fe := site.URLs[0]
+	if fe.Name != "frontends" || fe.State != "degraded" || len(fe.Children) != 3 || fe.Children[1].State != "offline" {
+		t.Errorf("frontends = %+v", fe)
+	}
+
+	if db := site.URLs[1]; db.Name != "db" || db.State != "online" || len(db.Children) != 1 {
+		t.Errorf("db = %+v", db)
+	}
+
+	b, err := json.Marshal(s.sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"name":"web tier","description":"","state":"online"`) {
+		t.Errorf("json = %s", b)
+	}
+}
+
+func TestCompositeMissingService(t *testing.T) {
+	s := testComposite([]string{"online", "online", "online"}, "online", map[string]interface{}{"rule": "any"})
+	s.cfg[2].URL = []string{"frontends", "cache"}
+	s.processSites()
+
+	site := s.sites.Sites[2]
+	if site.State != "online" || site.URLs[1].State != "unknown" {
+		t.Errorf("state = %q, cache = %q", site.State, site.URLs[1].State)
+	}
+}
+
+// Composites of composites count the inner composite as one member
+func TestCompositeNested(t *testing.T) {
+	s := testComposite([]string{"online", "degraded", "online"}, "online", nil)
+	s.cfg = append(s.cfg, &Config{
+		Name:     "site",
+		Type:     "composite",
+		URL:      []string{"web tier", "db"},
+		Options:  map[string]interface{}{"rule": "at_least", "min": 1},
+		state:    make([]string, 2),
+		children: make([][]*URL, 2),
+	})
+	s.processSites()
+
+	if state := s.sites.Sites[2].State; state != "degraded" {
+		t.Errorf("web tier = %q, expected degraded", state)
+	}
+	if state := s.sites.Sites[3].State; state != "online" {
+		t.Errorf("site = %q, expected online", state)
+	}
+	if u := s.sites.Sites[3].URLs[0]; u.State != "degraded" || len(u.Children) != 2 {
+		t.Errorf("web tier member = %+v", u)
+	}
+}
+
+// Composites aren't checked themselves, they follow the services they name
+func TestCompositeRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(testSimpleResponder))
+	defer ts.Close()
+
+	cfg := []*Config{
+		{
+			Name:    "frontends",
+			Type:    "website",
+			URL:     []string{ts.URL, ts.URL + "/2", "http://127.0.0.1:55555"},
+			state:   []string{"unknown", "unknown", "unknown"},
+			Timeout: 20,
+		},
+		{
+			Name:    "web tier",
+			Type:    "composite",
+			URL:     []string{"frontends"},
+			Options: map[string]interface{}{"rule": "at_least", "min": 2},
+			state:   []string{"unknown"},
+		},
+	}
+
+	s := &server{cfg: cfg}
+
+	s.refresh(Wait)
+
+	if state := s.sites.Sites[1].State; state != "online" {
+		t.Errorf("web tier = %q, expected online", state)
+	}
+	if u := s.sites.Sites[1].URLs[0]; u.State != "degraded" {
+		t.Errorf("frontends = %q, expected degraded", u.State)
+	}
+}
@@ -7,28 +7,119 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type Consul struct{}
 
+type consulOptions struct {
+	Service    string `yaml:"service"`
+	Datacenter string `yaml:"datacenter"`
+	Token      string `yaml:"token"`
+}
+
+type consulCheck struct {
+	CheckID     string  `json:"CheckID"`
+	CreateIndex float64 `json:"CreateIndex"`
+	ModifyIndex float64 `json:"ModifyIndex"`
+	Name        string  `json:"Name"`
+	Node        string  `json:"Node"`
+	Notes       string  `json:"Notes"`
+	Output      string  `json:"Output"`
+	ServiceID   string  `json:"ServiceID"`
+	ServiceName string  `json:"ServiceName"`
+	Status      string  `json:"Status"`
+}
+
+// Build the health endpoint, a URL naming one already is used as is
+func consulEndpoint(srv Service, opts *consulOptions) (string, error) {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(u.Path, "/v1/") {
+		base := strings.TrimRight(u.Path, "/")
+		if opts.Service != "" {
+			u.Path = base + "/v1/health/service/" + opts.Service
+		} else {
+			u.Path = base + "/v1/health/state/any"
+		}
+	}
+
+	if opts.Datacenter != "" {
+		q := u.Query()
+		q.Set("dc", opts.Datacenter)
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// Decode either a list of checks or, from /v1/health/service, a list of
+// service instances each carrying their checks
+func consulChecks(endpoint string, body io.Reader) ([]consulCheck, error) {
+	if !strings.Contains(endpoint, "/v1/health/service/") {
+		data := make([]consulCheck, 0)
+		err := json.NewDecoder(body).Decode(&data)
+		return data, err
+	}
+
+	entries := make([]struct {
+		Checks []consulCheck `json:"Checks"`
+	}, 0)
+
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	data := make([]consulCheck, 0)
+	for _, e := range entries {
+		data = append(data, e.Checks...)
+	}
+
+	return data, nil
+}
+
 func (c *Consul) Check(srv Service) (bool, error) {
+	state, _, err := c.CheckTree(srv)
+	return state == "online", err
+}
+
+// Evaluate every returned check, each is shown as a child
+func (c *Consul) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &consulOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	endpoint, err := consulEndpoint(srv, opts)
+	if err != nil {
+		return "offline", nil, err
+	}
+
 	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
 	client := &http.Client{Timeout: timeout}
 
-	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
-		return false, fmt.Errorf("newrequest: %v", err)
+		return "offline", nil, fmt.Errorf("newrequest: %v", err)
 	}
 
 	req.Close = true
 
+	if opts.Token != "" {
+		req.Header.Set("X-Consul-Token", opts.Token)
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, fmt.Errorf("client request: %v", err)
+		return "offline", nil, fmt.Errorf("client request: %v", err)
 	}
 	if resp == nil {
-		return false, fmt.Errorf("empty response")
+		return "offline", nil, fmt.Errorf("empty response")
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
@@ -36,35 +127,52 @@ func (c *Consul) Check(srv Service) (bool, error) {
 	}()
 
 	if resp.StatusCode != 200 {
-		return false, nil
-	}
-
-	type reply struct {
-		CheckID     string  `json:"CheckID"`
-		CreateIndex float64 `json:"CreateIndex"`
-		ModifyIndex float64 `json:"ModifyIndex"`
-		Name        string  `json:"Name"`
-		Node        string  `json:"Node"`
-		Notes       string  `json:"Notes"`
-		Output      string  `json:"Output"`
-		ServiceID   string  `json:"ServiceID"`
-		ServiceName string  `json:"ServiceName"`
-		Status      string  `json:"Status"`
+		return "offline", nil, fmt.Errorf("response status %d", resp.StatusCode)
 	}
 
-	data := make([]reply, 0)
-
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return false, fmt.Errorf("unmarshal: %v", err)
+	data, err := consulChecks(endpoint, resp.Body)
+	if err != nil {
+		return "offline", nil, fmt.Errorf("unmarshal: %v", err)
 	}
 
 	if len(data) < 1 {
-		return false, errors.New("too few elements in response")
+		return "offline", nil, errors.New("too few elements in response")
 	}
 
-	if data[0].Status != "passing" {
-		return false, nil
+	state := "online"
+	children := make([]*URL, 0, len(data))
+
+	for _, check := range data {
+		child := &URL{
+			Name:  check.Node + " " + check.Name,
+			State: "online",
+			URL:   endpoint,
+		}
+
+		if output := strings.TrimSpace(check.Output); output != "" {
+			if i := strings.IndexByte(output, '\n'); i >= 0 {
+				output = output[:i]
+			}
+			if len(output) > 80 {
+				output = output[:77] + "..."
+			}
+			child.Name += ": " + output
+		}
+
+		switch check.Status {
+		case "passing":
+		case "warning":
+			child.State = "degraded"
+			if state == "online" {
+				state = "degraded"
+			}
+		default:
+			child.State = "offline"
+			state = "offline"
+		}
+
+		children = append(children, child)
 	}
 
-	return true, nil
+	return state, children, nil
 }
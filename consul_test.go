@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testConsulCheck(name, status, output string) string {
+	return fmt.Sprintf(`{"Node": "node1", "CheckID": "%s", "Name": "%s", "Status": "%s", "Output": "%s"}`, name, name, status, output)
+}
+
+// Consul agent answering the health endpoints, requiring an ACL token
+func testConsul(checks ...string) http.HandlerFunc {
+	list := "[" + strings.Join(checks, ",") + "]"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "sekrit" {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("dc") != "dc1" {
+			http.Error(w, "No path to datacenter", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/health/state/any", "/v1/health/checks/web":
+			fmt.Fprint(w, list)
+		case "/v1/health/service/web":
+			fmt.Fprintf(w, `[{"Node": {"Node": "node1"}, "Service": {"Service": "web"}, "Checks": %s}]`, list)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func testConsulOptions(service string) map[string]interface{} {
+	return map[string]interface{}{
+		"service":    service,
+		"datacenter": "dc1",
+		"token":      "sekrit",
+	}
+}
+
+func TestCheckStatusConsul(t *testing.T) {
+	url := testServer(t, testConsul(
+		testConsulCheck("serfHealth", "passing", "Agent alive and reachable"),
+		testConsulCheck("web", "passing", "HTTP GET http://localhost/: 200 OK"),
+	))
+	testCheckService(t, "consul", url, testConsulOptions("web"), func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 2 || children[0].Name != "node1 serfHealth: Agent alive and reachable" {
+			t.Fatalf("unexpected children %+v", children)
+		}
+	})
+}
+
+func TestCheckStatusConsulStateAny(t *testing.T) {
+	url := testServer(t, testConsul(
+		testConsulCheck("serfHealth", "passing", ""),
+		testConsulCheck("web", "warning", "slow"),
+	))
+	testCheckService(t, "consul", url, testConsulOptions(""), degradedCheck)
+}
+
+func TestCheckStatusConsulCritical(t *testing.T) {
+	url := testServer(t, testConsul(
+		testConsulCheck("serfHealth", "passing", ""),
+		testConsulCheck("web", "warning", "slow"),
+		testConsulCheck("db", "critical", "connection refused"),
+	))
+	testCheckService(t, "consul", url, testConsulOptions("web"), func(t *testing.T, cfg []*Config) {
+		failCheck(t, cfg)
+		children := cfg[0].children[0]
+		for i, state := range []string{"online", "degraded", "offline"} {
+			if children[i].State != state {
+				t.Errorf("%s state %s, expected %s", children[i].Name, children[i].State, state)
+			}
+		}
+	})
+}
+
+func TestCheckStatusConsulEndpointURL(t *testing.T) {
+	url := testServer(t, testConsul(testConsulCheck("web", "passing", "")))
+	opts := map[string]interface{}{"datacenter": "dc1", "token": "sekrit"}
+	testCheckService(t, "consul", url+"/v1/health/checks/web", opts, successCheck)
+}
+
+func TestCheckStatusConsulNoToken(t *testing.T) {
+	url := testServer(t, testConsul(testConsulCheck("web", "passing", "")))
+	testCheckService(t, "consul", url, map[string]interface{}{"datacenter": "dc1"}, failCheck)
+}
+
+func TestCheckStatusConsulEmpty(t *testing.T) {
+	url := testServer(t, testConsul())
+	testCheckService(t, "consul", url, testConsulOptions(""), failCheck)
+}
+
+func TestCheckStatusConsulMissing(t *testing.T) {
+	testCheckStatus(t, "consul", nil, failCheck)
+}
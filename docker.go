@@ -1,29 +1,42 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
-type Docker struct {
-	transport *http.Transport
+type Docker struct{}
+
+// The TLS files in ~/.docker are loaded once and shared by every docker
+// and swarm check
+var dockerTLS struct {
 	once      sync.Once
+	transport *http.Transport
+}
+
+func dockerTLSTransport() *http.Transport {
+	dockerTLS.once.Do(func() { dockerTLS.transport = loadDockerTLS() })
+	return dockerTLS.transport
 }
 
-func (d *Docker) setupTLS() {
+func loadDockerTLS() *http.Transport {
 	home := os.Getenv("HOME")
 	if home == "" {
 		log.Println("HOME environment not configured")
-		return
+		return nil
 	}
 
 	certFile := home + "/.docker/cert.pem"
@@ -33,13 +46,13 @@ func (d *Docker) setupTLS() {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		log.Println(err)
-		return
+		return nil
 	}
 
 	caCert, err := ioutil.ReadFile(caFile)
 	if err != nil {
 		log.Println(err)
-		return
+		return nil
 	}
 
 	caCertPool := x509.NewCertPool()
@@ -50,14 +63,146 @@ func (d *Docker) setupTLS() {
 		RootCAs:      caCertPool,
 	}
 	tlsConfig.BuildNameToCertificate()
-	d.transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return &http.Transport{TLSClientConfig: tlsConfig}
+}
+
+type dockerOptions struct {
+	Containers bool     `yaml:"containers"`
+	Labels     []string `yaml:"labels"`
+	Names      []string `yaml:"names"`
+}
+
+// Talk HTTP over the daemon's unix socket, the host part of URLs is ignored
+func dockerUnixTransport(path string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
+
+func dockerGet(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	return nil
+}
+
+// List containers matching the label and name filters and report each as a
+// child, unhealthy, restarting or stopped containers are offline
+func (d *Docker) containers(client *http.Client, base string, opts *dockerOptions) ([]*URL, error) {
+	filters := make(map[string][]string)
+	if len(opts.Labels) > 0 {
+		filters["label"] = opts.Labels
+	}
+	if len(opts.Names) > 0 {
+		filters["name"] = opts.Names
+	}
+
+	b, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+	}, 0)
+
+	if err := dockerGet(client, base+"/containers/json?all=1&filters="+url.QueryEscape(string(b)), &list); err != nil {
+		return nil, err
+	}
+
+	children := make([]*URL, 0, len(list))
+
+	for _, c := range list {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		child := &URL{Name: name, State: "offline", URL: base + "/containers/" + c.ID + "/json"}
+		children = append(children, child)
+
+		info := struct {
+			State struct {
+				Status     string `json:"Status"`
+				Running    bool   `json:"Running"`
+				Restarting bool   `json:"Restarting"`
+				Health     *struct {
+					Status string `json:"Status"`
+				} `json:"Health"`
+			} `json:"State"`
+		}{}
+
+		if err := dockerGet(client, child.URL, &info); err != nil {
+			child.Name += " " + err.Error()
+			continue
+		}
+
+		status := info.State.Status
+		if info.State.Health != nil {
+			status = info.State.Health.Status
+		}
+		child.Name += " (" + status + ")"
+
+		switch {
+		case info.State.Restarting || !info.State.Running:
+		case info.State.Health == nil || info.State.Health.Status == "healthy":
+			child.State = "online"
+		case info.State.Health.Status == "starting":
+			child.State = "degraded"
+		}
+	}
+
+	return children, nil
 }
 
 func (d *Docker) Check(srv Service) (bool, error) {
+	state, _, err := d.CheckTree(srv)
+	return state == "online", err
+}
+
+func (d *Docker) CheckTree(srv Service) (string, []*URL, error) {
 	var err error
 	var resp *http.Response
 
-	d.once.Do(d.setupTLS)
+	opts := &dockerOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	base := srv.URL
+	var transport *http.Transport
+
+	if strings.HasPrefix(srv.URL, "unix://") {
+		base = "http://docker"
+		transport = dockerUnixTransport(strings.TrimPrefix(srv.URL, "unix://"))
+	} else {
+		transport = dockerTLSTransport()
+	}
 
 	expire := time.Now().Add(time.Duration(srv.Timeout) * time.Second)
 
@@ -65,15 +210,15 @@ func (d *Docker) Check(srv Service) (bool, error) {
 		timeout := time.Duration(5 * time.Second)
 		client := &http.Client{Timeout: timeout}
 
-		req, err := http.NewRequest(http.MethodGet, srv.URL+"/info", nil)
+		req, err := http.NewRequest(http.MethodGet, base+"/info", nil)
 		if err != nil {
-			return false, fmt.Errorf("newrequest: %v", err)
+			return "offline", nil, fmt.Errorf("newrequest: %v", err)
 		}
 
 		req.Close = true
 
-		if d.transport != nil {
-			client.Transport = d.transport
+		if transport != nil {
+			client.Transport = transport
 		}
 
 		resp, err = client.Do(req)
@@ -81,24 +226,42 @@ func (d *Docker) Check(srv Service) (bool, error) {
 			continue
 		}
 		if err != nil {
-			return false, fmt.Errorf("client request: %v", err)
+			return "offline", nil, fmt.Errorf("client request: %v", err)
 		}
 		break
 	}
 	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
-		return false, err
+		return "offline", nil, err
 	}
 	if resp == nil {
-		return false, fmt.Errorf("empty response")
+		return "offline", nil, fmt.Errorf("empty response")
 	}
-	defer func() {
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-	}()
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return false, nil
+		return "offline", nil, fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	if !opts.Containers {
+		return "online", nil, nil
+	}
+
+	client := &http.Client{Timeout: time.Duration(srv.Timeout) * time.Second}
+	if transport != nil {
+		client.Transport = transport
+	}
+
+	children, err := d.containers(client, base, opts)
+	if err != nil {
+		return "degraded", nil, err
+	}
+
+	for _, c := range children {
+		if c.State != "online" {
+			return "degraded", children, nil
+		}
 	}
 
-	return true, nil
+	return "online", children, nil
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -41,14 +44,16 @@ func TestDockerBad(t *testing.T) {
 
 func TestDockerHOME(t *testing.T) {
 	os.Setenv("HOME", "")
-	d := &Docker{}
-	d.setupTLS()
+	if loadDockerTLS() != nil {
+		t.Error("expected no transport without HOME")
+	}
 }
 
 func TestDockerNoCertFile(t *testing.T) {
 	os.Setenv("HOME", "/tmp")
-	d := &Docker{}
-	d.setupTLS()
+	if loadDockerTLS() != nil {
+		t.Error("expected no transport without cert files")
+	}
 }
 
 func TestCheckStatusDockerRealWorld(t *testing.T) {
@@ -70,3 +75,80 @@ func TestCheckStatusDockerRealWorld(t *testing.T) {
 		//time.Sleep(time.Millisecond * 10)
 	}
 }
+
+// Docker daemon on a unix socket with a web container that is healthy, a
+// worker without a health check and a db container in the given state
+func testDockerUnix(t *testing.T, dbState string) string {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info":
+			fmt.Fprint(w, `{"ID": "sitecheck"}`)
+		case "/containers/json":
+			var filters map[string][]string
+			json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+			if len(filters["label"]) != 1 || filters["label"][0] != "tier=web" {
+				t.Errorf("unexpected filters %v", filters)
+			}
+			fmt.Fprint(w, `[{"Id": "1", "Names": ["/web"]}, {"Id": "2", "Names": ["/worker"]}, {"Id": "3", "Names": ["/db"]}]`)
+		case "/containers/1/json":
+			fmt.Fprint(w, `{"State": {"Status": "running", "Running": true, "Health": {"Status": "healthy"}}}`)
+		case "/containers/2/json":
+			fmt.Fprint(w, `{"State": {"Status": "running", "Running": true}}`)
+		case "/containers/3/json":
+			fmt.Fprint(w, dbState)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(h)
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	return "unix://" + sock
+}
+
+func TestDockerUnix(t *testing.T) {
+	url := testDockerUnix(t, `{"State": {"Status": "running", "Running": true}}`)
+	testCheckService(t, "docker", url, nil, successCheck)
+}
+
+func TestDockerContainers(t *testing.T) {
+	opts := map[string]interface{}{
+		"containers": true,
+		"labels":     []interface{}{"tier=web"},
+	}
+
+	for _, tc := range []struct {
+		db      string
+		state   string
+		checker func(*testing.T, []*Config)
+	}{
+		{`{"State": {"Status": "running", "Running": true, "Health": {"Status": "healthy"}}}`, "online", successCheck},
+		{`{"State": {"Status": "running", "Running": true, "Health": {"Status": "starting"}}}`, "degraded", degradedCheck},
+		{`{"State": {"Status": "running", "Running": true, "Health": {"Status": "unhealthy"}}}`, "offline", degradedCheck},
+		{`{"State": {"Status": "restarting", "Running": true, "Restarting": true}}`, "offline", degradedCheck},
+		{`{"State": {"Status": "exited", "Running": false}}`, "offline", degradedCheck},
+	} {
+		url := testDockerUnix(t, tc.db)
+		testCheckService(t, "docker", url, opts, func(t *testing.T, cfg []*Config) {
+			tc.checker(t, cfg)
+			children := cfg[0].children[0]
+			if len(children) != 3 {
+				t.Fatalf("expected 3 children, got %d", len(children))
+			}
+			for i, state := range []string{"online", "online", tc.state} {
+				if children[i].State != state {
+					t.Errorf("%s state %s, expected %s", children[i].Name, children[i].State, state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Elasticsearch struct{}
+
+type elasticsearchOptions struct {
+	Username            string `yaml:"username"`
+	Password            string `yaml:"password"`
+	MaxUnassignedShards int    `yaml:"max_unassigned_shards"`
+	MaxPendingTasks     int    `yaml:"max_pending_tasks"`
+	tlsOptions          `yaml:",inline"`
+}
+
+func (e *Elasticsearch) Check(srv Service) (bool, error) {
+	state, err := e.CheckState(srv)
+	return state == "online", err
+}
+
+// Map the cluster health colour onto a state, green clusters exceeding a
+// threshold are degraded
+func (e *Elasticsearch) CheckState(srv Service) (string, error) {
+	opts := &elasticsearchOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", fmt.Errorf("options: %v", err)
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return "offline", fmt.Errorf("tls: %v", err)
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: cfg},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(srv.URL, "/")+"/_cluster/health", nil)
+	if err != nil {
+		return "offline", fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "offline", fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	// a red cluster answers 503 when wait_for_status is used, but
+	// still reports its health
+	if resp.StatusCode != 200 && resp.StatusCode != 503 {
+		return "offline", fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	health := struct {
+		ClusterName          string `json:"cluster_name"`
+		Status               string `json:"status"`
+		UnassignedShards     int    `json:"unassigned_shards"`
+		NumberOfPendingTasks int    `json:"number_of_pending_tasks"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return "offline", fmt.Errorf("unmarshal: %v", err)
+	}
+
+	state := "offline"
+	switch health.Status {
+	case "green":
+		state = "online"
+	case "yellow":
+		state = "degraded"
+	case "red":
+		return "offline", nil
+	default:
+		return "offline", fmt.Errorf("unknown cluster status %q", health.Status)
+	}
+
+	// a threshold being exceeded makes things one step worse
+	exceeded := false
+
+	if opts.MaxUnassignedShards > 0 && health.UnassignedShards > opts.MaxUnassignedShards {
+		log.Printf("elasticsearch %s: %d unassigned shards\n", srv.URL, health.UnassignedShards)
+		exceeded = true
+	}
+
+	if opts.MaxPendingTasks > 0 && health.NumberOfPendingTasks > opts.MaxPendingTasks {
+		log.Printf("elasticsearch %s: %d pending tasks\n", srv.URL, health.NumberOfPendingTasks)
+		exceeded = true
+	}
+
+	if exceeded {
+		if state == "online" {
+			return "degraded", nil
+		}
+		return "offline", nil
+	}
+
+	return state, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testClusterHealth(status string, unassigned, pending int) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/health" {
+			http.NotFound(w, r)
+			return
+		}
+		if status == "red" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, `{"cluster_name": "sitecheck", "status": "%s", "unassigned_shards": %d, "number_of_pending_tasks": %d}`,
+			status, unassigned, pending)
+	}
+}
+
+func TestCheckStatusElasticsearchGreen(t *testing.T) {
+	testCheckStatus(t, "elasticsearch", testClusterHealth("green", 0, 0), successCheck)
+}
+
+func TestCheckStatusElasticsearchYellow(t *testing.T) {
+	testCheckStatus(t, "elasticsearch", testClusterHealth("yellow", 5, 0), degradedCheck)
+}
+
+func TestCheckStatusElasticsearchRed(t *testing.T) {
+	testCheckStatus(t, "elasticsearch", testClusterHealth("red", 10, 0), failCheck)
+}
+
+func TestCheckStatusElasticsearchThresholds(t *testing.T) {
+	opts := map[string]interface{}{
+		"max_unassigned_shards": 4,
+		"max_pending_tasks":     10,
+	}
+
+	for _, tc := range []struct {
+		status     string
+		unassigned int
+		pending    int
+		checker    func(*testing.T, []*Config)
+	}{
+		{"green", 0, 10, successCheck},
+		{"green", 0, 11, degradedCheck},
+		{"yellow", 4, 0, degradedCheck},
+		{"yellow", 5, 0, failCheck},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(testClusterHealth(tc.status, tc.unassigned, tc.pending)))
+		testCheckService(t, "elasticsearch", ts.URL, opts, tc.checker)
+		ts.Close()
+	}
+}
+
+func TestCheckStatusElasticsearchAuth(t *testing.T) {
+	health := testClusterHealth("green", 0, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "elastic" || pass != "sekrit" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		health(w, r)
+	}))
+	defer ts.Close()
+
+	testCheckService(t, "elasticsearch", ts.URL, nil, failCheck)
+	testCheckService(t, "elasticsearch", ts.URL, map[string]interface{}{"username": "elastic", "password": "sekrit"}, successCheck)
+}
+
+func TestCheckStatusElasticsearchBad(t *testing.T) {
+	testCheckStatus(t, "elasticsearch", testSimpleResponder, failCheck)
+}
+
+func TestCheckStatusElasticsearchMissing(t *testing.T) {
+	testCheckStatus(t, "elasticsearch", nil, failCheck)
+}
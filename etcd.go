@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type Etcd struct{}
 
+type etcdOptions struct {
+	MaxIndexLag uint64 `yaml:"max_index_lag"`
+	MaxDBSize   int64  `yaml:"max_db_size"`
+}
+
 type memb struct {
 	ClientURLs []string `json:"clientURLs"`
 	ID         string   `json:"id"`
@@ -51,65 +56,305 @@ func etcdMembers(url string, client *http.Client) (error, *members) {
 	return nil, members
 }
 
-// Iterate over all members looking for health
+type etcdMemberV3 struct {
+	ID         uint64   `json:"ID,string"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+}
+
+type etcdStatus struct {
+	Version   string   `json:"version"`
+	DBSize    int64    `json:"dbSize,string"`
+	Leader    uint64   `json:"leader,string"`
+	RaftIndex uint64   `json:"raftIndex,string"`
+	RaftTerm  uint64   `json:"raftTerm,string"`
+	Errors    []string `json:"errors"`
+}
+
+// A server that answered, but not as the v3 JSON gateway does
+type etcdNoV3 struct {
+	err error
+}
+
+func (e etcdNoV3) Error() string { return e.err.Error() }
+
+// POST to the v3 JSON gateway, every call we make takes an empty request
+func etcdPostV3(url, path string, client *http.Client, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, url+path, strings.NewReader("{}"))
+	if err != nil {
+		return fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case 200:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return etcdNoV3{fmt.Errorf("%s: response status %d", path, resp.StatusCode)}
+	default:
+		return fmt.Errorf("%s: response status %d", path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return etcdNoV3{fmt.Errorf("unmarshal: %v", err)}
+	}
+
+	return nil
+}
+
+// Read the status of a single member, also used to probe for v3 support
+func etcdStatusV3(url string, client *http.Client) (*etcdStatus, error) {
+	status := &etcdStatus{}
+
+	if err := etcdPostV3(url, "/v3/maintenance/status", client, status); err != nil {
+		return nil, err
+	}
+
+	if status.Version == "" {
+		return nil, etcdNoV3{errors.New("not an etcd v3 status")}
+	}
+
+	return status, nil
+}
+
+// Read all members of an etcd cluster through the v3 gateway
+func etcdMembersV3(url string, client *http.Client) ([]etcdMemberV3, error) {
+	list := struct {
+		Members []etcdMemberV3 `json:"members"`
+	}{}
+
+	if err := etcdPostV3(url, "/v3/cluster/member/list", client, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Members, nil
+}
+
+// Fetch the status of every member, reporting each as a child.  Members
+// disagreeing with the leader's term, lagging behind the highest raft index
+// or carrying alarms are degraded.
+func (e *Etcd) checkV3(url string, client *http.Client, opts *etcdOptions) (string, []*URL, error) {
+	members, err := etcdMembersV3(url, client)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	children := make([]*URL, len(members))
+	statuses := make([]*etcdStatus, len(members))
+
+	var maxIndex, leaderTerm uint64
+	votes := make(map[uint64]int)
+
+	for i, m := range members {
+		children[i] = &URL{Name: m.Name, State: "offline", URL: url}
+		if len(m.ClientURLs) > 0 {
+			children[i].URL = m.ClientURLs[0]
+		}
+
+		for _, u := range m.ClientURLs {
+			status, err := etcdStatusV3(u, client)
+			if err != nil {
+				continue
+			}
+			statuses[i] = status
+			break
+		}
+
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+
+		if status.Leader != 0 {
+			votes[status.Leader]++
+		}
+		if status.Leader == m.ID {
+			leaderTerm = status.RaftTerm
+		}
+		if status.RaftIndex > maxIndex {
+			maxIndex = status.RaftIndex
+		}
+	}
+
+	var leader uint64
+	for id, n := range votes {
+		if n > votes[leader] {
+			leader = id
+		}
+	}
+
+	for i, m := range members {
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+
+		child := children[i]
+		child.State = "online"
+
+		if m.ID == leader {
+			child.Name += " leader"
+		}
+		child.Name += fmt.Sprintf(" (term %d, index %d, db %.1f MB)", status.RaftTerm, status.RaftIndex, float64(status.DBSize)/(1<<20))
+
+		switch {
+		case status.Leader != leader:
+			child.State = "degraded"
+		case leaderTerm != 0 && status.RaftTerm != leaderTerm:
+			child.State = "degraded"
+		case opts.MaxIndexLag > 0 && maxIndex-status.RaftIndex > opts.MaxIndexLag:
+			child.State = "degraded"
+		case opts.MaxDBSize > 0 && status.DBSize > opts.MaxDBSize:
+			child.State = "degraded"
+		case len(status.Errors) > 0:
+			child.State = "degraded"
+			child.Name += " " + strings.Join(status.Errors, ", ")
+		}
+	}
+
+	if leader == 0 {
+		return "offline", children, errors.New("no leader")
+	}
+
+	return etcdQuorum(children), children, nil
+}
+
 func (e *Etcd) Check(srv Service) (bool, error) {
+	state, _, err := e.CheckTree(srv)
+	return state == "online", err
+}
+
+// Use the v3 gateway when the cluster has one, otherwise the v2 API.  A
+// cluster that can't be reached is offline without trying v2 as well.
+func (e *Etcd) CheckTree(srv Service) (string, []*URL, error) {
 	if srv.Timeout == 0 {
 		srv.Timeout = 30
 	}
 
+	opts := &etcdOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
 	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
 	client := &http.Client{Timeout: timeout}
 
-	health := false
+	_, err := etcdStatusV3(srv.URL, client)
+	switch err.(type) {
+	case nil:
+		return e.checkV3(srv.URL, client, opts)
+	case etcdNoV3:
+		return e.checkV2(srv.URL, client)
+	}
 
-	err, members := etcdMembers(srv.URL, client)
+	return "offline", nil, err
+}
+
+// Ask a single v2 member for its health
+func etcdHealth(url string, client *http.Client) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url+"/health", nil)
 	if err != nil {
-		return health, err
+		return false, fmt.Errorf("newrequest: %v", err)
 	}
 
-	for _, m := range members.Members {
-		for _, url := range m.ClientURLs {
-			req, err := http.NewRequest(http.MethodGet, url+"/health", nil)
-			if err != nil {
-				return false, fmt.Errorf("newrequest: %v", err)
-			}
+	req.Close = true
 
-			req.Close = true
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Printf("failed to check health of member %s on %s: %v\n", m.ID, url, err)
-				continue
-			}
-			if resp == nil {
-				log.Printf("response body empty, member %s on %s\n", m.ID, url)
-				continue
-			}
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("/health response status %d", resp.StatusCode)
+	}
 
-			if resp.StatusCode != 200 {
-				log.Printf("/health not found, member %s on %s\n", m.ID, url)
-				continue
-			}
+	result := struct{ Health string }{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("unmarshal: %v", err)
+	}
 
-			result := struct{ Health string }{}
-			err = json.NewDecoder(resp.Body).Decode(&result)
-			io.Copy(ioutil.Discard, resp.Body)
-			resp.Body.Close()
+	return result.Health == "true", nil
+}
+
+// Iterate over all members looking for health, each member is reported as
+// a child
+func (e *Etcd) checkV2(url string, client *http.Client) (string, []*URL, error) {
+	err, members := etcdMembers(url, client)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	children := make([]*URL, 0)
+
+	for _, m := range members.Members {
+		child := &URL{Name: m.Name, State: "offline", URL: url}
+		if child.Name == "" {
+			child.Name = m.ID
+		}
+		if len(m.ClientURLs) > 0 {
+			child.URL = m.ClientURLs[0]
+		}
+
+		err := errors.New("no client URLs")
+		for _, u := range m.ClientURLs {
+			var health bool
+			health, err = etcdHealth(u, client)
 			if err != nil {
-				fmt.Printf("failed to check the health of member %s on %s: %v\n", m.ID, url, err)
 				continue
 			}
-
-			if result.Health == "true" {
-				health = true
-				// fmt.Printf("member %s is healthy: got healthy result from %s\n", m.ID, url)
+			if health {
+				child.State = "online"
 			} else {
-				fmt.Printf("member %s is unhealthy: got unhealthy result from %s\n", m.ID, url)
+				child.Name += " unhealthy"
 			}
-
 			break
 		}
+		if err != nil {
+			child.Name += " " + err.Error()
+		}
+
+		children = append(children, child)
+	}
+
+	return etcdQuorum(children), children, nil
+}
+
+// Online when every member is healthy, degraded while a majority of members
+// remain reachable and offline once quorum is lost
+func etcdQuorum(children []*URL) string {
+	online, alive := 0, 0
+	for _, c := range children {
+		if c.State == "online" {
+			online++
+		}
+		if c.State != "offline" {
+			alive++
+		}
+	}
+
+	switch {
+	case len(children) == 0:
+		return "offline"
+	case online == len(children):
+		return "online"
+	case alive > len(children)/2:
+		return "degraded"
 	}
 
-	return health, nil
+	return "offline"
 }
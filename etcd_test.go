@@ -3,9 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCheckStatusEtcd(t *testing.T) {
@@ -186,3 +189,233 @@ func TestCheckStatusEtcdBadHealth(t *testing.T) {
 
 	testCheckStatus(t, "etcd", f, failCheck)
 }
+
+// A v3 member answering the JSON gateway, the first member also answers
+// the member list for the whole cluster
+type testEtcdV3 struct {
+	id      uint64
+	leader  uint64
+	term    uint64
+	index   uint64
+	errors  []string
+	url     string
+	cluster []*testEtcdV3
+}
+
+func (m *testEtcdV3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/maintenance/status":
+		b, _ := json.Marshal(m.errors)
+		fmt.Fprintf(w, `{"header": {"member_id": "%d"}, "version": "3.5.9", "dbSize": "2097152", "leader": "%d", "raftIndex": "%d", "raftTerm": "%d", "errors": %s}`,
+			m.id, m.leader, m.index, m.term, b)
+	case "/v3/cluster/member/list":
+		members := make([]string, 0)
+		for _, c := range m.cluster {
+			members = append(members, fmt.Sprintf(`{"ID": "%d", "name": "etcd%d", "clientURLs": ["%s"]}`, c.id, c.id, c.url))
+		}
+		fmt.Fprintf(w, `{"members": [%s]}`, strings.Join(members, ","))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testEtcdV3Cluster(t *testing.T, members ...*testEtcdV3) string {
+	for _, m := range members {
+		m.cluster = members
+		if m.url == "" {
+			m.url = testServer(t, m)
+		}
+	}
+	return members[0].url
+}
+
+func TestCheckStatusEtcdV3(t *testing.T) {
+	url := testEtcdV3Cluster(t,
+		&testEtcdV3{id: 1, leader: 2, term: 4, index: 100},
+		&testEtcdV3{id: 2, leader: 2, term: 4, index: 101},
+		&testEtcdV3{id: 3, leader: 2, term: 4, index: 100},
+	)
+	testCheckService(t, "etcd", url, nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 3 {
+			t.Fatalf("expected 3 children, got %d", len(children))
+		}
+		if children[1].Name != "etcd2 leader (term 4, index 101, db 2.0 MB)" {
+			t.Errorf("unexpected child %s", children[1].Name)
+		}
+	})
+}
+
+func TestCheckStatusEtcdV3Divergence(t *testing.T) {
+	url := testEtcdV3Cluster(t,
+		&testEtcdV3{id: 1, leader: 2, term: 4, index: 100},
+		&testEtcdV3{id: 2, leader: 2, term: 4, index: 5000},
+		&testEtcdV3{id: 3, leader: 2, term: 3, index: 5000},
+	)
+	testCheckService(t, "etcd", url, map[string]interface{}{"max_index_lag": 1000}, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		children := cfg[0].children[0]
+		for i, state := range []string{"degraded", "online", "degraded"} {
+			if children[i].State != state {
+				t.Errorf("%s state %s, expected %s", children[i].Name, children[i].State, state)
+			}
+		}
+	})
+}
+
+func TestCheckStatusEtcdV3Alarm(t *testing.T) {
+	url := testEtcdV3Cluster(t,
+		&testEtcdV3{id: 1, leader: 1, term: 4, index: 100, errors: []string{"memberID:1 alarm:NOSPACE"}},
+	)
+	testCheckService(t, "etcd", url, nil, degradedCheck)
+}
+
+func TestCheckStatusEtcdV3MemberDown(t *testing.T) {
+	url := testEtcdV3Cluster(t,
+		&testEtcdV3{id: 1, leader: 1, term: 4, index: 100},
+		&testEtcdV3{id: 2, leader: 1, term: 4, index: 100},
+		&testEtcdV3{id: 3, url: "http://127.0.0.1:55555"},
+	)
+	testCheckService(t, "etcd", url, nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		if cfg[0].children[0][2].State != "offline" {
+			t.Errorf("unreachable member is %s", cfg[0].children[0][2].State)
+		}
+	})
+}
+
+func TestCheckStatusEtcdV3NoLeader(t *testing.T) {
+	url := testEtcdV3Cluster(t,
+		&testEtcdV3{id: 1, term: 4, index: 100},
+		&testEtcdV3{id: 2, term: 4, index: 100},
+	)
+	testCheckService(t, "etcd", url, nil, failCheck)
+}
+
+// v2 members answering /health, the first also answers /v2/members
+func testEtcdV2Cluster(t *testing.T, health ...string) string {
+	m := &members{}
+
+	for i, h := range health {
+		h := h
+		url := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/members":
+				json.NewEncoder(w).Encode(m)
+			case "/health":
+				if h == "" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"health": "%s"}`, h)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		m.Members = append(m.Members, memb{
+			ClientURLs: []string{url},
+			ID:         fmt.Sprint(i),
+			Name:       fmt.Sprintf("etcd%d", i),
+		})
+	}
+
+	return m.Members[0].ClientURLs[0]
+}
+
+func TestCheckStatusEtcdQuorum(t *testing.T) {
+	for _, tc := range []struct {
+		health  []string
+		checker func(*testing.T, []*Config)
+	}{
+		{[]string{"true", "true", "true"}, successCheck},
+		{[]string{"true", "true", "false"}, degradedCheck},
+		{[]string{"true", "", "true"}, degradedCheck},
+		{[]string{"true", "false", ""}, failCheck},
+		{[]string{"true", "true", "false", "false"}, failCheck},
+	} {
+		url := testEtcdV2Cluster(t, tc.health...)
+		testCheckService(t, "etcd", url, nil, func(t *testing.T, cfg []*Config) {
+			tc.checker(t, cfg)
+			children := cfg[0].children[0]
+			if len(children) != len(tc.health) {
+				t.Fatalf("expected %d children, got %d", len(tc.health), len(children))
+			}
+			for i, h := range tc.health {
+				if (h == "true") != (children[i].State == "online") {
+					t.Errorf("%s health %q state %s", children[i].Name, h, children[i].State)
+				}
+				name := fmt.Sprintf("etcd%d", i)
+				switch h {
+				case "false":
+					name += " unhealthy"
+				case "":
+					name += " /health response status 404"
+				}
+				if children[i].Name != name {
+					t.Errorf("child %d = %q, expected %q", i, children[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+// Only a server without the v3 gateway is asked for the v2 API
+func TestCheckStatusEtcdNoFallback(t *testing.T) {
+	v2 := false
+	url := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			v2 = true
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+
+	testCheckService(t, "etcd", url, nil, failCheck)
+
+	if v2 {
+		t.Error("fell back to v2 after a v3 server error")
+	}
+}
+
+func TestCheckStatusEtcdUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept, but never answer
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    "etcd",
+		URL:     []string{"http://" + l.Addr().String()},
+		state:   []string{"unknown"},
+		Timeout: 1,
+	}}
+
+	s := &server{cfg: cfg}
+
+	start := time.Now()
+	s.refresh(Wait)
+
+	failCheck(t, cfg)
+
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("check took %s, expected one timeout", elapsed)
+	}
+}
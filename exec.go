@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Exec struct{}
+
+type execOptions struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+}
+
+// How long output is read after the plugin exits or is killed
+const execWaitDelay = time.Second
+
+// Nagios plugin exit codes
+var execStates = map[int]string{
+	0: "online",
+	1: "degraded",
+	2: "offline",
+	3: "unknown",
+}
+
+// A performance data value, 'label'=value[UOM];[warn];[crit];[min];[max]
+type perfdata struct {
+	Label string
+	Value float64
+	UOM   string
+	Warn  string
+	Crit  string
+}
+
+// Split perfdata into its values, labels may be quoted and hold spaces
+func parsePerfdata(s string) ([]perfdata, error) {
+	metrics := make([]perfdata, 0)
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var label string
+
+		if s[0] == '\'' {
+			end := strings.Index(s[1:], "'=")
+			if end < 0 {
+				return metrics, fmt.Errorf("unterminated label in %q", s)
+			}
+			label, s = s[1:end+1], s[end+3:]
+		} else {
+			eq := strings.IndexByte(s, '=')
+			if eq < 0 {
+				return metrics, fmt.Errorf("missing value in %q", s)
+			}
+			label, s = s[:eq], s[eq+1:]
+		}
+
+		field := s
+		if i := strings.IndexByte(s, ' '); i >= 0 {
+			field, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+
+		parts := strings.Split(field, ";")
+
+		num := strings.TrimRightFunc(parts[0], func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r == '.')
+		})
+
+		value, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return metrics, fmt.Errorf("%s: bad value %q", label, parts[0])
+		}
+
+		m := perfdata{Label: label, Value: value, UOM: parts[0][len(num):]}
+		if len(parts) > 1 {
+			m.Warn = parts[1]
+		}
+		if len(parts) > 2 {
+			m.Crit = parts[2]
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics, nil
+}
+
+// Report whether a value raises an alert for a Nagios threshold range:
+// "10" alerts outside 0..10, "10:" below 10, "~:10" above 10, "10:20"
+// outside 10..20, and a leading "@" alerts inside the range instead
+func nagiosAlert(threshold string, value float64) (bool, error) {
+	if threshold == "" {
+		return false, nil
+	}
+
+	inside := strings.HasPrefix(threshold, "@")
+	threshold = strings.TrimPrefix(threshold, "@")
+
+	low, high := "0", threshold
+	if i := strings.IndexByte(threshold, ':'); i >= 0 {
+		low, high = threshold[:i], threshold[i+1:]
+	}
+
+	min, max := 0.0, 0.0
+	var err error
+
+	if low == "~" {
+		min = -1e308
+	} else if min, err = strconv.ParseFloat(low, 64); err != nil {
+		return false, fmt.Errorf("bad threshold %q", threshold)
+	}
+
+	if high == "" {
+		max = 1e308
+	} else if max, err = strconv.ParseFloat(high, 64); err != nil {
+		return false, fmt.Errorf("bad threshold %q", threshold)
+	}
+
+	in := value >= min && value <= max
+	if inside {
+		return in, nil
+	}
+	return !in, nil
+}
+
+// The state of a metric against its warning and critical thresholds
+func (m perfdata) state() string {
+	if alert, err := nagiosAlert(m.Crit, m.Value); alert || err != nil {
+		return "offline"
+	}
+	if alert, err := nagiosAlert(m.Warn, m.Value); alert || err != nil {
+		return "degraded"
+	}
+	return "online"
+}
+
+// Split plugin output into the first line's text and all of its perfdata,
+// which follows a "|" on the first line and on any later line
+func parsePluginOutput(out string) (string, []perfdata, error) {
+	lines := strings.SplitN(out, "\n", 2)
+
+	message, perf := lines[0], ""
+	if i := strings.IndexByte(message, '|'); i >= 0 {
+		message, perf = message[:i], message[i+1:]
+	}
+
+	if len(lines) > 1 {
+		if i := strings.IndexByte(lines[1], '|'); i >= 0 {
+			perf += " " + strings.Join(strings.Fields(lines[1][i+1:]), " ")
+		}
+	}
+
+	metrics, err := parsePerfdata(perf)
+	return strings.TrimSpace(message), metrics, err
+}
+
+func (e *Exec) Check(srv Service) (bool, error) {
+	state, _, err := e.CheckTree(srv)
+	return state == "online", err
+}
+
+// Run a command, by default the URL, as a Nagios plugin.  "$URL" in the
+// arguments is replaced by the service URL.  The plugin's message and its
+// perfdata values, rated by their thresholds, are shown as children.
+func (e *Exec) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &execOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	command := opts.Command
+	if command == "" {
+		command = srv.URL
+	}
+
+	args := make([]string, len(opts.Args))
+	for i, a := range opts.Args {
+		args[i] = strings.Replace(a, "$URL", srv.URL, -1)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// stop collecting output once the plugin has gone, whatever it left
+	// running still holding it open
+	cmd.WaitDelay = execWaitDelay
+
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(opts.Env))
+	for k := range opts.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+opts.Env[k])
+	}
+
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return "offline", nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timer := time.NewTimer(time.Duration(srv.Timeout) * time.Second)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killProcessGroup(cmd)
+		<-done
+		return "offline", nil, errors.New("timeout")
+	}
+
+	code := 0
+	if err != nil && err != exec.ErrWaitDelay {
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			return "offline", nil, err
+		}
+		code = exit.ExitCode()
+	}
+
+	state, ok := execStates[code]
+	if !ok {
+		state = "unknown"
+	}
+
+	message, metrics, perr := parsePluginOutput(stdout.String())
+	if message == "" {
+		message = strings.SplitN(strings.TrimSpace(stderr.String()), "\n", 2)[0]
+	}
+
+	children := make([]*URL, 0, len(metrics)+1)
+	if message != "" {
+		children = append(children, &URL{Name: message, State: state, URL: srv.URL})
+	}
+	for _, m := range metrics {
+		children = append(children, &URL{
+			Name:  fmt.Sprintf("%s %g%s", m.Label, m.Value, m.UOM),
+			State: m.state(),
+			URL:   srv.URL,
+		})
+	}
+
+	switch {
+	case state != "online":
+		return state, children, fmt.Errorf("exit %d: %s", code, message)
+	case perr != nil:
+		return state, children, fmt.Errorf("perfdata: %v", perr)
+	}
+
+	return state, children, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Write an executable shell script
+func testScript(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "check_test")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecMissing(t *testing.T) {
+	testCheckService(t, "exec", "/nonexistent/check_nothing", nil, failCheck)
+}
+
+func TestExecStates(t *testing.T) {
+	for code, state := range execStates {
+		script := testScript(t, `echo "TEST state"; exit `+strconv.Itoa(code))
+
+		testCheckService(t, "exec", script, nil, func(t *testing.T, cfg []*Config) {
+			if cfg[0].state[0] != state {
+				t.Errorf("exit %d: state = %s, expected %s", code, cfg[0].state[0], state)
+			}
+		})
+	}
+
+	testCheckService(t, "exec", testScript(t, "exit 7"), nil, func(t *testing.T, cfg []*Config) {
+		if cfg[0].state[0] != "unknown" {
+			t.Errorf("exit 7: state = %s, expected unknown", cfg[0].state[0])
+		}
+	})
+}
+
+func TestExecPerfdata(t *testing.T) {
+	script := testScript(t, `echo "DISK WARNING - free space: / 3326 MB (9%);| /=2643MB;5948;5958;0;5968"
+echo "/boot 68 MB (69%);"
+echo "/var 3326 MB | /boot=68MB;88;93;0;98"
+echo "'/var log'=9000MB;8000;9500;0;10000"
+exit 1`)
+
+	testCheckService(t, "exec", script, nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		names := make([]string, 0)
+		states := make([]string, 0)
+		for _, c := range cfg[0].children[0] {
+			names = append(names, c.Name)
+			states = append(states, c.State)
+		}
+
+		expected := []string{"DISK WARNING - free space: / 3326 MB (9%);", "/ 2643MB", "/boot 68MB", "/var log 9000MB"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("children = %q, expected %q", names, expected)
+		}
+		if expectedStates := []string{"degraded", "online", "online", "degraded"}; !reflect.DeepEqual(states, expectedStates) {
+			t.Errorf("states = %v, expected %v", states, expectedStates)
+		}
+	})
+}
+
+func TestExecArgsEnv(t *testing.T) {
+	script := testScript(t, `[ "$1" = "-H" ] && [ "$2" = "host=$CHECK_HOST" ] || { echo "bad args $*"; exit 2; }
+echo "OK"`)
+
+	options := map[string]interface{}{
+		"command": script,
+		"args":    []interface{}{"-H", "host=$URL"},
+		"env":     map[string]interface{}{"CHECK_HOST": "db1.example.com"},
+	}
+
+	testCheckService(t, "exec", "db1.example.com", options, successCheck)
+}
+
+// A timeout kills the whole process group, including background children
+// that would otherwise hold stdout open
+func TestExecTimeout(t *testing.T) {
+	script := testScript(t, "sleep 30 &\nsleep 30")
+
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    "exec",
+		URL:     []string{script},
+		state:   []string{"unknown"},
+		Timeout: 1,
+	}}
+
+	s := &server{cfg: cfg}
+
+	start := time.Now()
+	s.refresh(Wait)
+
+	failCheck(t, cfg)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("check took %s", elapsed)
+	}
+}
+
+// Descendants that left the process group keep the output open
+func TestExecEscaped(t *testing.T) {
+	script := testScript(t, "setsid sleep 8 &\nsleep 30")
+
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    "exec",
+		URL:     []string{script},
+		state:   []string{"unknown"},
+		Timeout: 1,
+	}}
+
+	s := &server{cfg: cfg}
+
+	start := time.Now()
+	s.refresh(Wait)
+
+	failCheck(t, cfg)
+
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("check took %s", elapsed)
+	}
+
+	// a plugin that exits leaving a daemon behind still reports
+	script = testScript(t, "setsid sleep 8 &\necho 'OK - started'")
+
+	testCheckService(t, "exec", script, nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		if name := cfg[0].children[0][0].Name; name != "OK - started" {
+			t.Errorf("child = %q", name)
+		}
+	})
+}
+
+func TestNagiosAlert(t *testing.T) {
+	tests := []struct {
+		threshold string
+		value     float64
+		alert     bool
+	}{
+		{"", 100, false},
+		{"10", 5, false},
+		{"10", 11, true},
+		{"10", -1, true},
+		{"10:", 9, true},
+		{"10:", 1000, false},
+		{"~:10", -1000, false},
+		{"~:10", 11, true},
+		{"10:20", 15, false},
+		{"10:20", 21, true},
+		{"@10:20", 15, true},
+		{"@10:20", 5, false},
+	}
+
+	for _, tt := range tests {
+		alert, err := nagiosAlert(tt.threshold, tt.value)
+		if err != nil {
+			t.Errorf("%q: %v", tt.threshold, err)
+		}
+		if alert != tt.alert {
+			t.Errorf("%q with %g: alert = %v, expected %v", tt.threshold, tt.value, alert, tt.alert)
+		}
+	}
+
+	if _, err := nagiosAlert("x:y", 1); err == nil {
+		t.Error("expected error for bad threshold")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type File struct{}
+
+type fileOptions struct {
+	MaxAge  time.Duration `yaml:"max_age"`
+	MinSize int64         `yaml:"min_size"`
+	MaxSize int64         `yaml:"max_size"`
+	Content string        `yaml:"content"`
+}
+
+// Content is only searched this far into a file
+const fileContentLimit = 16 << 20
+
+// The path of a file:// URL, which may be a glob
+func filePath(u string) (string, error) {
+	if !strings.HasPrefix(u, "file://") {
+		return "", fmt.Errorf("not a file:// URL: %s", u)
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(u, "file://"), "localhost")
+	if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("not an absolute path: %s", u)
+	}
+
+	return path, nil
+}
+
+// The most recently modified file matching a glob
+func fileNewest(pattern string) (string, os.FileInfo, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var newest string
+	var info os.FileInfo
+
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if info == nil || fi.ModTime().After(info.ModTime()) {
+			newest, info = m, fi
+		}
+	}
+
+	if info == nil {
+		return "", nil, errors.New("no such file")
+	}
+
+	return newest, info, nil
+}
+
+func fileContains(path string, re *regexp.Regexp) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(f, fileContentLimit))
+	if err != nil {
+		return false, err
+	}
+
+	return re.Match(b), nil
+}
+
+func (f *File) Check(srv Service) (bool, error) {
+	state, _, err := f.CheckTree(srv)
+	return state == "online", err
+}
+
+// Stat a file:// path, checking the newest match when it is a glob, and
+// assert its age, size and content.  The file is shown as a child.
+func (f *File) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &fileOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	var re *regexp.Regexp
+	if opts.Content != "" {
+		var err error
+		if re, err = regexp.Compile(opts.Content); err != nil {
+			return "offline", nil, fmt.Errorf("content: %v", err)
+		}
+	}
+
+	pattern, err := filePath(srv.URL)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	path, info, err := fileNewest(pattern)
+	if err != nil {
+		return "offline", nil, fmt.Errorf("%s: %v", pattern, err)
+	}
+
+	age := time.Since(info.ModTime())
+
+	child := &URL{
+		Name:  fmt.Sprintf("%s (%s old)", path, age.Round(time.Second)),
+		State: "offline",
+		URL:   "file://" + path,
+	}
+	if !info.IsDir() {
+		child.Name = fmt.Sprintf("%s (%d bytes, %s old)", path, info.Size(), age.Round(time.Second))
+	}
+	children := []*URL{child}
+
+	if opts.MaxAge > 0 && age > opts.MaxAge {
+		return "offline", children, fmt.Errorf("%s modified %s ago", path, age.Round(time.Second))
+	}
+
+	if !info.IsDir() {
+		if info.Size() < opts.MinSize {
+			return "offline", children, fmt.Errorf("%s is %d bytes, below %d", path, info.Size(), opts.MinSize)
+		}
+		if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+			return "offline", children, fmt.Errorf("%s is %d bytes, above %d", path, info.Size(), opts.MaxSize)
+		}
+	}
+
+	if re != nil {
+		if info.IsDir() {
+			return "offline", children, fmt.Errorf("%s is a directory", path)
+		}
+		found, err := fileContains(path, re)
+		if err != nil {
+			return "offline", children, err
+		}
+		if !found {
+			return "offline", children, fmt.Errorf("%s doesn't match %q", path, opts.Content)
+		}
+	}
+
+	child.State = "online"
+
+	return "online", children, nil
+}
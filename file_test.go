@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a file modified age ago
+func testFile(t *testing.T, path, content string, age time.Duration) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	tests := []struct {
+		url  string
+		path string
+		ok   bool
+	}{
+		{"file:///data/export.csv", "/data/export.csv", true},
+		{"file:///data/export-?.csv", "/data/export-?.csv", true},
+		{"file://localhost/data/*.csv", "/data/*.csv", true},
+		{"file://data/export.csv", "", false},
+		{"/data/export.csv", "", false},
+	}
+
+	for _, tt := range tests {
+		path, err := filePath(tt.url)
+		if (err == nil) != tt.ok || path != tt.path {
+			t.Errorf("%s = %q, %v", tt.url, path, err)
+		}
+	}
+}
+
+func TestFileMissing(t *testing.T) {
+	testCheckService(t, "file", "file://"+filepath.Join(t.TempDir(), "missing"), nil, failCheck)
+}
+
+func TestFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	testFile(t, path, "id,name\n1,foo\n", 2*time.Hour)
+
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"max_age": "3h"}, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != path+" (14 bytes, 2h0m0s old)" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"max_age": "1h"}, failCheck)
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"max_age": "a while"}, failCheck)
+}
+
+func TestFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	testFile(t, path, strings.Repeat("x", 100), 0)
+
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"min_size": 50, "max_size": 200}, successCheck)
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"min_size": 101}, failCheck)
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"max_size": 99}, failCheck)
+}
+
+func TestFileContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	testFile(t, path, "id,name\n1,foo\n# END OF EXPORT\n", 0)
+
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"content": "(?m)^# END OF EXPORT$"}, successCheck)
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"content": "ERROR"}, failCheck)
+	testCheckService(t, "file", "file://"+path, map[string]interface{}{"content": "("}, failCheck)
+}
+
+// A glob checks its newest match
+func TestFileGlob(t *testing.T) {
+	dir := t.TempDir()
+	testFile(t, filepath.Join(dir, "export-1.csv"), "old", 48*time.Hour)
+	testFile(t, filepath.Join(dir, "export-2.csv"), "new", time.Hour)
+	testFile(t, filepath.Join(dir, "other.txt"), "newest", 0)
+
+	testCheckService(t, "file", "file://"+dir+"/export-?.csv", map[string]interface{}{"max_age": "2h"}, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; !strings.HasPrefix(name, filepath.Join(dir, "export-2.csv")) {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "file", "file://"+dir+"/*.json", nil, failCheck)
+}
+
+func TestFileDirectory(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	os.Chtimes(dir, mtime, mtime)
+
+	testCheckService(t, "file", "file://"+dir, map[string]interface{}{"max_age": "2h"}, successCheck)
+	testCheckService(t, "file", "file://"+dir, map[string]interface{}{"max_age": "30m"}, failCheck)
+	testCheckService(t, "file", "file://"+dir, map[string]interface{}{"content": "x"}, failCheck)
+}
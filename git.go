@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Git struct{}
+
+type gitOptions struct {
+	Username   string        `yaml:"username"`
+	Password   string        `yaml:"password"`
+	Branch     string        `yaml:"branch"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	tlsOptions `yaml:",inline"`
+}
+
+// When each watched ref was last seen to move, kept across checks
+var gitHeads = struct {
+	sync.Mutex
+	m map[string]gitHead
+}{m: make(map[string]gitHead)}
+
+type gitHead struct {
+	oid   string
+	since time.Time
+}
+
+// Packet lines are prefixed by their length in four hex digits, including
+// the prefix; 0000 is a flush and 0001 a delimiter
+func gitPktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+// Read a packet line, returning "" for flush and delimiter packets
+func gitReadPkt(r *bufio.Reader) (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad packet length %q", size)
+	}
+	if n < 4 {
+		return "", nil
+	}
+
+	b := make([]byte, n-4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// Parse a protocol v0 ref advertisement up to its flush, the first line
+// carries capabilities including the HEAD symref
+func gitReadRefsV0(r *bufio.Reader, first string) (map[string]string, string, error) {
+	refs := make(map[string]string)
+	head := ""
+
+	for line := first; line != ""; {
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			for _, c := range strings.Fields(line[i+1:]) {
+				if strings.HasPrefix(c, "symref=HEAD:") {
+					head = strings.TrimPrefix(c, "symref=HEAD:")
+				}
+			}
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, "", fmt.Errorf("malformed ref %q", line)
+		}
+		if fields[1] != "capabilities^{}" {
+			refs[fields[1]] = fields[0]
+		}
+
+		var err error
+		if line, err = gitReadPkt(r); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return refs, head, nil
+}
+
+// Discover refs through the smart HTTP upload-pack advertisement
+func gitRefsHTTP(srv Service, opts *gitOptions) (map[string]string, string, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, "", fmt.Errorf("tls: %v", err)
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: cfg},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(srv.URL, "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("response status %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		return nil, "", fmt.Errorf("not a smart HTTP server, content type %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+
+	service, err := gitReadPkt(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if service != "# service=git-upload-pack" {
+		return nil, "", fmt.Errorf("unexpected service line %q", service)
+	}
+
+	if flush, err := gitReadPkt(r); err != nil || flush != "" {
+		return nil, "", fmt.Errorf("missing flush after service line")
+	}
+
+	first, err := gitReadPkt(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return gitReadRefsV0(r, first)
+}
+
+// Discover refs over git://, using ls-refs when the daemon speaks
+// protocol v2 and the initial advertisement otherwise
+func gitRefsDaemon(u *url.URL, timeout time.Duration) (map[string]string, string, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "9418")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprint(conn, gitPktLine("git-upload-pack "+u.Path+"\x00host="+u.Host+"\x00\x00version=2\x00"))
+
+	r := bufio.NewReader(conn)
+
+	first, err := gitReadPkt(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("advertisement: %v", err)
+	}
+	if strings.HasPrefix(first, "ERR ") {
+		return nil, "", errors.New(strings.TrimPrefix(first, "ERR "))
+	}
+
+	if first != "version 2" {
+		return gitReadRefsV0(r, first)
+	}
+
+	lsRefs := false
+	for {
+		line, err := gitReadPkt(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("capabilities: %v", err)
+		}
+		if line == "" {
+			break
+		}
+		if line == "ls-refs" || strings.HasPrefix(line, "ls-refs=") {
+			lsRefs = true
+		}
+	}
+	if !lsRefs {
+		return nil, "", errors.New("server lacks ls-refs")
+	}
+
+	fmt.Fprint(conn, gitPktLine("command=ls-refs\n")+"0001"+gitPktLine("symrefs\n")+"0000")
+
+	refs := make(map[string]string)
+	head := ""
+
+	for {
+		line, err := gitReadPkt(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("ls-refs: %v", err)
+		}
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, "", errors.New(strings.TrimPrefix(line, "ERR "))
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, "", fmt.Errorf("malformed ref %q", line)
+		}
+		refs[fields[1]] = fields[0]
+
+		for _, attr := range fields[2:] {
+			if fields[1] == "HEAD" && strings.HasPrefix(attr, "symref-target:") {
+				head = strings.TrimPrefix(attr, "symref-target:")
+			}
+		}
+	}
+
+	return refs, head, nil
+}
+
+func (g *Git) Check(srv Service) (bool, error) {
+	state, _, err := g.CheckTree(srv)
+	return state == "online", err
+}
+
+// List the refs a repository serves and show the watched branch, HEAD
+// unless configured, as a child.  With max_age set the branch is degraded
+// when its head hasn't moved for that long while being watched.
+func (g *Git) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &gitOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	var refs map[string]string
+	var head string
+
+	switch u.Scheme {
+	case "http", "https":
+		refs, head, err = gitRefsHTTP(srv, opts)
+	case "git":
+		refs, head, err = gitRefsDaemon(u, time.Duration(srv.Timeout)*time.Second)
+	default:
+		err = fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	if len(refs) == 0 {
+		return "offline", nil, errors.New("repository has no refs")
+	}
+
+	branch := "HEAD"
+	if opts.Branch != "" {
+		branch = opts.Branch
+		if !strings.HasPrefix(branch, "refs/") {
+			branch = "refs/heads/" + branch
+		}
+	}
+
+	oid, ok := refs[branch]
+	if !ok {
+		return "offline", nil, fmt.Errorf("%s not found", branch)
+	}
+
+	name := strings.TrimPrefix(branch, "refs/heads/")
+	if branch == "HEAD" && head != "" {
+		name = strings.TrimPrefix(head, "refs/heads/")
+	}
+	if len(oid) > 7 {
+		oid = oid[:7]
+	}
+
+	child := &URL{
+		Name:  name + " " + oid,
+		State: "online",
+		URL:   srv.URL,
+	}
+
+	if opts.MaxAge > 0 {
+		key := srv.URL + " " + branch
+
+		gitHeads.Lock()
+		h, ok := gitHeads.m[key]
+		if !ok || h.oid != refs[branch] {
+			h = gitHead{oid: refs[branch], since: time.Now()}
+			gitHeads.m[key] = h
+		}
+		gitHeads.Unlock()
+
+		age := time.Since(h.since)
+		child.Name += fmt.Sprintf(" (unchanged %s)", age.Round(time.Minute))
+		if age > opts.MaxAge {
+			child.State = "degraded"
+		}
+	}
+
+	return child.State, []*URL{child}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testGitMain = "1111111111111111111111111111111111111111"
+	testGitDev  = "2222222222222222222222222222222222222222"
+)
+
+func testGitHTTP(t *testing.T) string {
+	return testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repo.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		fmt.Fprint(w, gitPktLine("# service=git-upload-pack\n")+"0000"+
+			gitPktLine(testGitMain+" HEAD\x00multi_ack side-band-64k symref=HEAD:refs/heads/main agent=git/2.40\n")+
+			gitPktLine(testGitDev+" refs/heads/dev\n")+
+			gitPktLine(testGitMain+" refs/heads/main\n")+
+			"0000")
+	})) + "/repo.git"
+}
+
+// Fake git daemon, answering ls-refs over protocol v2 or advertising refs
+// directly like daemons predating it
+func testGitDaemon(t *testing.T, v2 bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+
+				req, err := gitReadPkt(r)
+				if err != nil {
+					return
+				}
+				if !strings.HasPrefix(req, "git-upload-pack /repo.git\x00host=") || !strings.HasSuffix(req, "\x00\x00version=2\x00") {
+					t.Errorf("request = %q", req)
+				}
+
+				if !v2 {
+					fmt.Fprint(conn, gitPktLine(testGitMain+" HEAD\x00symref=HEAD:refs/heads/main\n")+
+						gitPktLine(testGitMain+" refs/heads/main\n")+"0000")
+					return
+				}
+
+				fmt.Fprint(conn, gitPktLine("version 2\n")+gitPktLine("agent=git/2.40\n")+
+					gitPktLine("ls-refs=unborn\n")+gitPktLine("fetch=shallow\n")+"0000")
+
+				var delim, flush [4]byte
+				cmd, _ := gitReadPkt(r)
+				io.ReadFull(r, delim[:])
+				arg, _ := gitReadPkt(r)
+				io.ReadFull(r, flush[:])
+				if cmd != "command=ls-refs" || string(delim[:]) != "0001" || arg != "symrefs" || string(flush[:]) != "0000" {
+					t.Errorf("ls-refs request = %q %q %q %q", cmd, delim, arg, flush)
+				}
+
+				fmt.Fprint(conn, gitPktLine(testGitMain+" HEAD symref-target:refs/heads/main\n")+
+					gitPktLine(testGitDev+" refs/heads/dev\n")+
+					gitPktLine(testGitMain+" refs/heads/main\n")+"0000")
+			}()
+		}
+	}()
+
+	return "git://" + ln.Addr().String() + "/repo.git"
+}
+
+func testGitChild(name string) func(*testing.T, []*Config) {
+	return func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 1 || children[0].Name != name {
+			t.Fatalf("children = %v, expected %s", children, name)
+		}
+	}
+}
+
+func TestGitMissing(t *testing.T) {
+	testCheckStatus(t, "git", nil, failCheck)
+}
+
+func TestGitDumbHTTP(t *testing.T) {
+	testCheckStatus(t, "git", testSimpleResponder, failCheck)
+}
+
+func TestGitHTTP(t *testing.T) {
+	url := testGitHTTP(t)
+
+	testCheckService(t, "git", url, nil, testGitChild("main 1111111"))
+	testCheckService(t, "git", url, map[string]interface{}{"branch": "dev"}, testGitChild("dev 2222222"))
+	testCheckService(t, "git", url, map[string]interface{}{"branch": "release"}, failCheck)
+}
+
+func TestGitDaemonV2(t *testing.T) {
+	url := testGitDaemon(t, true)
+
+	testCheckService(t, "git", url, nil, testGitChild("main 1111111"))
+	testCheckService(t, "git", url, map[string]interface{}{"branch": "refs/heads/dev"}, testGitChild("dev 2222222"))
+}
+
+func TestGitDaemonV0(t *testing.T) {
+	testCheckService(t, "git", testGitDaemon(t, false), nil, testGitChild("main 1111111"))
+}
+
+func TestGitMaxAge(t *testing.T) {
+	url := testGitHTTP(t)
+	options := map[string]interface{}{"branch": "main", "max_age": "1h"}
+
+	testCheckService(t, "git", url, options, successCheck)
+
+	key := url + " refs/heads/main"
+
+	gitHeads.Lock()
+	gitHeads.m[key] = gitHead{oid: testGitMain, since: time.Now().Add(-2 * time.Hour)}
+	gitHeads.Unlock()
+
+	testCheckService(t, "git", url, options, degradedCheck)
+
+	// a moved head is fresh again
+	gitHeads.Lock()
+	gitHeads.m[key] = gitHead{oid: testGitDev, since: time.Now().Add(-2 * time.Hour)}
+	gitHeads.Unlock()
+
+	testCheckService(t, "git", url, options, successCheck)
+	testCheckService(t, "git", url, map[string]interface{}{"max_age": "a while"}, failCheck)
+}
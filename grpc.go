@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+type GRPC struct{}
+
+type grpcOptions struct {
+	Service    string            `yaml:"service"`
+	Metadata   map[string]string `yaml:"metadata"`
+	tlsOptions `yaml:",inline"`
+}
+
+func (g *GRPC) Check(srv Service) (bool, error) {
+	state, err := g.CheckState(srv)
+	return state == "online", err
+}
+
+// Call grpc.health.v1.Health/Check on the service and map the serving
+// status onto a sitecheck state
+func (g *GRPC) CheckState(srv Service) (string, error) {
+	opts := &grpcOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", fmt.Errorf("options: %v", err)
+	}
+
+	target := srv.URL
+	switch {
+	case strings.HasPrefix(target, "grpcs://"):
+		target = strings.TrimPrefix(target, "grpcs://")
+		opts.TLS = true
+	case strings.HasPrefix(target, "grpc://"):
+		target = strings.TrimPrefix(target, "grpc://")
+	}
+
+	creds := insecure.NewCredentials()
+	if opts.TLS {
+		cfg, err := opts.config()
+		if err != nil {
+			return "offline", fmt.Errorf("tls: %v", err)
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return "offline", fmt.Errorf("client: %v", err)
+	}
+	defer conn.Close()
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if len(opts.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(opts.Metadata))
+	}
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: opts.Service})
+	if err != nil {
+		return "offline", fmt.Errorf("health check: %v", err)
+	}
+
+	switch resp.Status {
+	case healthpb.HealthCheckResponse_SERVING:
+		return "online", nil
+	case healthpb.HealthCheckResponse_NOT_SERVING:
+		return "offline", nil
+	}
+
+	return "degraded", nil
+}
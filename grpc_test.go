@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func testGRPCServer(t *testing.T, status healthpb.HealthCheckResponse_ServingStatus, opts ...grpc.ServerOption) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := health.NewServer()
+	hs.SetServingStatus("sitecheck", status)
+
+	gs := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(gs, hs)
+
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+
+	return l.Addr().String()
+}
+
+func TestCheckStatusGRPC(t *testing.T) {
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_SERVING)
+	testCheckService(t, "grpc", addr, map[string]interface{}{"service": "sitecheck"}, successCheck)
+}
+
+func TestCheckStatusGRPCScheme(t *testing.T) {
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_SERVING)
+	testCheckService(t, "grpc", "grpc://"+addr, map[string]interface{}{"service": "sitecheck"}, successCheck)
+}
+
+func TestCheckStatusGRPCNotServing(t *testing.T) {
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_NOT_SERVING)
+	testCheckService(t, "grpc", addr, map[string]interface{}{"service": "sitecheck"}, failCheck)
+}
+
+func TestCheckStatusGRPCUnknown(t *testing.T) {
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_UNKNOWN)
+	testCheckService(t, "grpc", addr, map[string]interface{}{"service": "sitecheck"}, degradedCheck)
+}
+
+func TestCheckStatusGRPCNoService(t *testing.T) {
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_SERVING)
+	testCheckService(t, "grpc", addr, map[string]interface{}{"service": "fumble"}, failCheck)
+}
+
+func TestCheckStatusGRPCMetadata(t *testing.T) {
+	token := make(chan string, 1)
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			token <- md.Get("authorization")[0]
+		}
+		return handler(ctx, req)
+	}
+
+	addr := testGRPCServer(t, healthpb.HealthCheckResponse_SERVING, grpc.UnaryInterceptor(unary))
+
+	opts := map[string]interface{}{
+		"service":  "sitecheck",
+		"metadata": map[string]interface{}{"authorization": "Bearer fumble"},
+	}
+	testCheckService(t, "grpc", addr, opts, successCheck)
+
+	select {
+	case auth := <-token:
+		if auth != "Bearer fumble" {
+			t.Fatalf("authorization metadata = %q", auth)
+		}
+	default:
+		t.Fatal("no authorization metadata")
+	}
+}
+
+func TestCheckStatusGRPCMissing(t *testing.T) {
+	testCheckService(t, "grpc", "127.0.0.1:55555", nil, failCheck)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Heartbeat struct{}
+
+type heartbeatOptions struct {
+	Token       string        `yaml:"token"`
+	Period      time.Duration `yaml:"period"`
+	Grace       time.Duration `yaml:"grace"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// What has been heard from a job, by token
+type heartbeat struct {
+	watched  time.Time // first checked, the reference until a ping arrives
+	last     time.Time // last success
+	started  time.Time // start of the running job, zero when not running
+	duration time.Duration
+	failed   bool
+}
+
+var heartbeats = struct {
+	sync.Mutex
+	m map[string]*heartbeat
+}{m: make(map[string]*heartbeat)}
+
+// Record a ping, event is "" or "finish" for success, "start" or "fail"
+func heartbeatPing(token, event string, now time.Time) error {
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+
+	h, ok := heartbeats.m[token]
+	if !ok {
+		h = &heartbeat{watched: now}
+		heartbeats.m[token] = h
+	}
+
+	switch event {
+	case "start":
+		h.started = now
+	case "", "finish":
+		h.last = now
+		h.failed = false
+		if !h.started.IsZero() {
+			h.duration = now.Sub(h.started)
+			h.started = time.Time{}
+		}
+	case "fail":
+		h.failed = true
+		h.started = time.Time{}
+	default:
+		return fmt.Errorf("unknown event %q", event)
+	}
+
+	return nil
+}
+
+// Accept POST /api/v1/heartbeat/{token}[/start|/finish|/fail] for tokens
+// configured in heartbeat options
+func (s *server) heartbeatAPI(w http.ResponseWriter, r *http.Request) {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/heartbeat/")
+	token, event := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		token, event = path[:i], path[i+1:]
+	}
+
+	s.parseConfig()
+
+	name, ok := s.heartbeatToken(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := heartbeatPing(token, event, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Println("heartbeat", name, event, "from", host)
+
+	fmt.Fprintln(w, "OK")
+}
+
+// The name of the heartbeat service a token belongs to
+func (s *server) heartbeatToken(token string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, c := range s.cfg {
+		if c.Type != "heartbeat" {
+			continue
+		}
+		// only the token, so pings are still taken with bad durations
+		opts := &struct {
+			Token string `yaml:"token"`
+		}{}
+		if err := (Service{Options: c.Options}).decodeOptions(opts); err != nil || opts.Token == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(opts.Token), []byte(token)) == 1 {
+			return c.Name, true
+		}
+	}
+
+	return "", false
+}
+
+func (h *Heartbeat) Check(srv Service) (bool, error) {
+	state, _, err := h.CheckTree(srv)
+	return state == "online", err
+}
+
+// A heartbeat is offline when no successful ping with its token arrived
+// within period plus grace or the last run failed.  Runs that announced
+// their start are timed against max_duration.  The URL is only a label,
+// the token is kept out of the status shown to everyone.
+func (h *Heartbeat) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &heartbeatOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	if opts.Token == "" {
+		return "offline", nil, errors.New("token not configured")
+	}
+	if opts.Period <= 0 {
+		return "offline", nil, errors.New("period not configured")
+	}
+
+	now := time.Now()
+
+	heartbeats.Lock()
+	hb, ok := heartbeats.m[opts.Token]
+	if !ok {
+		hb = &heartbeat{watched: now}
+		heartbeats.m[opts.Token] = hb
+	}
+	b := *hb
+	heartbeats.Unlock()
+
+	children := make([]*URL, 0)
+	child := func(name, state string) {
+		children = append(children, &URL{Name: name, State: state, URL: srv.URL})
+	}
+
+	state := "online"
+	var err error
+
+	since := b.last
+	if since.IsZero() {
+		since = b.watched
+		child("no ping yet", "unknown")
+	} else {
+		child(fmt.Sprintf("last ping %s ago", now.Sub(b.last).Round(time.Second)), "online")
+	}
+
+	if now.Sub(since) > opts.Period+opts.Grace {
+		state = "offline"
+		children[0].State = "offline"
+		err = fmt.Errorf("no ping for %s", now.Sub(since).Round(time.Second))
+	}
+
+	if b.failed {
+		state = "offline"
+		child("last run failed", "offline")
+		err = errors.New("last run failed")
+	}
+
+	if b.duration > 0 {
+		s := "online"
+		if opts.MaxDuration > 0 && b.duration > opts.MaxDuration {
+			s = "degraded"
+		}
+		child(fmt.Sprintf("last run took %s", b.duration.Round(time.Second)), s)
+	}
+
+	if !b.started.IsZero() {
+		s := "online"
+		if opts.MaxDuration > 0 && now.Sub(b.started) > opts.MaxDuration {
+			s = "degraded"
+		}
+		child(fmt.Sprintf("running for %s", now.Sub(b.started).Round(time.Second)), s)
+	}
+
+	for _, c := range children {
+		if state == "online" && c.State == "degraded" {
+			state = "degraded"
+		}
+	}
+
+	return state, children, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHeartbeatOptions(token string) map[string]interface{} {
+	return map[string]interface{}{
+		"token":        token,
+		"period":       "24h",
+		"grace":        "1h",
+		"max_duration": "30m",
+	}
+}
+
+func testHeartbeatChildren(names ...string) func(*testing.T, []*Config) {
+	return func(t *testing.T, cfg []*Config) {
+		children := cfg[0].children[0]
+		if len(children) != len(names) {
+			t.Fatalf("children = %d, expected %d", len(children), len(names))
+		}
+		for i, name := range names {
+			if children[i].Name != name {
+				t.Errorf("child %d = %q, expected %q", i, children[i].Name, name)
+			}
+		}
+	}
+}
+
+func TestHeartbeatAPI(t *testing.T) {
+	s := &server{cfg: []*Config{{
+		Name:    "backup",
+		Type:    "heartbeat",
+		URL:     []string{"nightly"},
+		Options: testHeartbeatOptions("hb-api"),
+	}}}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/api/v1/heartbeat/hb-api", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/heartbeat/unknown", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/heartbeat/nightly", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/heartbeat/hb-api/restart", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/heartbeat/hb-api/start", http.StatusOK},
+		{http.MethodPost, "/api/v1/heartbeat/hb-api", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.heartbeatAPI(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s = %d, expected %d", tt.method, tt.path, w.Code, tt.status)
+		}
+	}
+
+	heartbeats.Lock()
+	hb := *heartbeats.m["hb-api"]
+	heartbeats.Unlock()
+
+	if hb.last.IsZero() || !hb.started.IsZero() {
+		t.Errorf("heartbeat = %+v, expected a finished run", hb)
+	}
+}
+
+// The token must not leak into the unauthenticated status
+func TestHeartbeatTokenHidden(t *testing.T) {
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-hidden"), func(t *testing.T, cfg []*Config) {
+		s := &server{cfg: cfg}
+		s.processSites()
+
+		b, err := json.Marshal(s.sites)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "hb-hidden") {
+			t.Errorf("token in status: %s", b)
+		}
+	})
+}
+
+func TestHeartbeatWaiting(t *testing.T) {
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-waiting"), func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		testHeartbeatChildren("no ping yet")(t, cfg)
+	})
+
+	heartbeats.Lock()
+	heartbeats.m["hb-waiting"].watched = time.Now().Add(-26 * time.Hour)
+	heartbeats.Unlock()
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-waiting"), failCheck)
+}
+
+func TestHeartbeatLate(t *testing.T) {
+	heartbeatPing("hb-late", "", time.Now().Add(-20*time.Hour))
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-late"), func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		testHeartbeatChildren("last ping 20h0m0s ago")(t, cfg)
+	})
+
+	heartbeatPing("hb-late", "", time.Now().Add(-25*time.Hour-time.Minute))
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-late"), failCheck)
+}
+
+func TestHeartbeatDuration(t *testing.T) {
+	now := time.Now()
+
+	heartbeatPing("hb-duration", "start", now.Add(-2*time.Hour))
+	heartbeatPing("hb-duration", "finish", now.Add(-time.Hour))
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-duration"), func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		testHeartbeatChildren("last ping 1h0m0s ago", "last run took 1h0m0s")(t, cfg)
+	})
+
+	heartbeatPing("hb-duration", "start", now.Add(-10*time.Minute))
+	heartbeatPing("hb-duration", "finish", now.Add(-5*time.Minute))
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-duration"), successCheck)
+
+	heartbeatPing("hb-duration", "start", now.Add(-45*time.Minute))
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-duration"), func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		if c := cfg[0].children[0][2]; c.State != "degraded" {
+			t.Errorf("running child = %s %s", c.Name, c.State)
+		}
+	})
+}
+
+func TestHeartbeatFail(t *testing.T) {
+	heartbeatPing("hb-fail", "start", time.Now())
+	heartbeatPing("hb-fail", "fail", time.Now())
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-fail"), failCheck)
+
+	heartbeatPing("hb-fail", "", time.Now())
+
+	testCheckService(t, "heartbeat", "nightly", testHeartbeatOptions("hb-fail"), successCheck)
+}
+
+func TestHeartbeatNoPeriod(t *testing.T) {
+	testCheckService(t, "heartbeat", "nightly", map[string]interface{}{"token": "hb-noperiod"}, failCheck)
+}
+
+func TestHeartbeatBadPeriod(t *testing.T) {
+	testCheckService(t, "heartbeat", "nightly", map[string]interface{}{"token": "hb-badperiod", "period": "daily"}, failCheck)
+}
+
+func TestHeartbeatNoToken(t *testing.T) {
+	testCheckService(t, "heartbeat", "nightly", map[string]interface{}{"period": "24h"}, failCheck)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Where the local host's process information is read from
+var procRoot = "/proc"
+
+// Space on the filesystem holding a path, replaced in tests
+var diskUsage = statfsUsage
+
+type Disk struct{}
+
+type Memory struct{}
+
+type Load struct{}
+
+type Process struct{}
+
+type hostOptions struct {
+	DegradedUsage float64 `yaml:"degraded_usage"`
+	OfflineUsage  float64 `yaml:"offline_usage"`
+}
+
+type loadOptions struct {
+	DegradedLoad float64 `yaml:"degraded_load"`
+	OfflineLoad  float64 `yaml:"offline_load"`
+}
+
+type processOptions struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// Rate a value against degraded and offline thresholds
+func hostState(value, degraded, offline float64) string {
+	switch {
+	case value > offline:
+		return "offline"
+	case value > degraded:
+		return "degraded"
+	}
+	return "online"
+}
+
+func hostResult(state, name, url string) (string, []*URL, error) {
+	children := []*URL{{Name: name, State: state, URL: url}}
+	if state != "online" {
+		return state, children, errors.New(name)
+	}
+	return state, children, nil
+}
+
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Parse /proc/meminfo into bytes by field
+func readMeminfo() (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			v *= 1024
+		}
+		info[strings.TrimSuffix(fields[0], ":")] = v
+	}
+
+	return info, scanner.Err()
+}
+
+// The kernel's limit on command names in /proc/<pid>/comm
+const procCommLen = 15
+
+// The base name of the program a process was started as, from argv[0]
+func procProgram(pid string) string {
+	cmdline, err := ioutil.ReadFile(filepath.Join(procRoot, pid, "cmdline"))
+	if err != nil {
+		return ""
+	}
+
+	if i := bytes.IndexByte(cmdline, 0); i >= 0 {
+		cmdline = cmdline[:i]
+	}
+
+	return filepath.Base(string(cmdline))
+}
+
+// Count the CPUs listed in /proc/stat
+func cpuCount() (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "cpu") && len(line) > 3 && line[3] >= '0' && line[3] <= '9' {
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0, errors.New("no cpus in stat")
+	}
+
+	return n, nil
+}
+
+func (d *Disk) Check(srv Service) (bool, error) {
+	state, _, err := d.CheckTree(srv)
+	return state == "online", err
+}
+
+// Usage of the filesystem holding the URL path
+func (d *Disk) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &hostOptions{DegradedUsage: 85, OfflineUsage: 95}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	path := strings.TrimPrefix(srv.URL, "file://")
+
+	total, avail, err := diskUsage(path)
+	if err != nil {
+		return "offline", nil, err
+	}
+	if total == 0 {
+		return "offline", nil, fmt.Errorf("%s has no size", path)
+	}
+
+	used := 100 * float64(total-avail) / float64(total)
+	name := fmt.Sprintf("%s %.1f%% used, %s free", path, used, humanBytes(avail))
+
+	return hostResult(hostState(used, opts.DegradedUsage, opts.OfflineUsage), name, srv.URL)
+}
+
+func (m *Memory) Check(srv Service) (bool, error) {
+	state, _, err := m.CheckTree(srv)
+	return state == "online", err
+}
+
+// Memory in use, counting what the kernel reports available as free
+func (m *Memory) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &hostOptions{DegradedUsage: 90, OfflineUsage: 95}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	info, err := readMeminfo()
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	total := info["MemTotal"]
+	avail, ok := info["MemAvailable"]
+	if !ok {
+		avail = info["MemFree"] + info["Buffers"] + info["Cached"]
+	}
+	if total == 0 || avail > total {
+		return "offline", nil, errors.New("malformed meminfo")
+	}
+
+	used := 100 * float64(total-avail) / float64(total)
+	name := fmt.Sprintf("memory %.1f%% used, %s available", used, humanBytes(avail))
+
+	return hostResult(hostState(used, opts.DegradedUsage, opts.OfflineUsage), name, srv.URL)
+}
+
+func (l *Load) Check(srv Service) (bool, error) {
+	state, _, err := l.CheckTree(srv)
+	return state == "online", err
+}
+
+// The five minute load average per CPU
+func (l *Load) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &loadOptions{DegradedLoad: 1, OfflineLoad: 2}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return "offline", nil, fmt.Errorf("malformed loadavg %q", data)
+	}
+
+	load, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return "offline", nil, fmt.Errorf("malformed loadavg %q", data)
+	}
+
+	cpus, err := cpuCount()
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	name := fmt.Sprintf("load %s %s %s, %d cpus", fields[0], fields[1], fields[2], cpus)
+
+	return hostResult(hostState(load/float64(cpus), opts.DegradedLoad, opts.OfflineLoad), name, srv.URL)
+}
+
+func (p *Process) Check(srv Service) (bool, error) {
+	state, _, err := p.CheckTree(srv)
+	return state == "online", err
+}
+
+// Count processes whose command name is the URL.  Fewer than min, by
+// default 1, is offline and more than max degraded.  The kernel cuts
+// command names short, so longer names are matched against the program
+// each process was started as.
+func (p *Process) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &processOptions{Min: 1}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	dirs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	count := 0
+	for _, d := range dirs {
+		if _, err := strconv.Atoi(d.Name()); err != nil || !d.IsDir() {
+			continue
+		}
+
+		// processes may exit while we look
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, d.Name(), "comm"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "offline", nil, err
+		}
+
+		command := strings.TrimSpace(string(comm))
+		if len(srv.URL) > procCommLen && command == srv.URL[:procCommLen] {
+			command = procProgram(d.Name())
+		}
+
+		if command == srv.URL {
+			count++
+		}
+	}
+
+	name := fmt.Sprintf("%s: %d processes", srv.URL, count)
+
+	state := "online"
+	switch {
+	case count < opts.Min:
+		state = "offline"
+	case opts.Max > 0 && count > opts.Max:
+		state = "degraded"
+	}
+
+	return hostResult(state, name, srv.URL)
+}
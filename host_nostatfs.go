@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "errors"
+
+func statfsUsage(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk usage not supported on this platform")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMeminfo = `MemTotal:        8000000 kB
+MemFree:          500000 kB
+MemAvailable:    2000000 kB
+Buffers:          100000 kB
+Cached:          1000000 kB
+SwapTotal:             0 kB
+`
+
+const testStat = `cpu  10132153 290696 3084719 46828483 16683 0 25195 0 0 0
+cpu0 1393280 32966 572056 13343292 6130 0 17875 0 0 0
+cpu1 1335168 35026 505547 13478052 3709 0 2488 0 0 0
+cpu2 1289386 37016 508318 13437613 3414 0 2357 0 0 0
+cpu3 1334210 38163 517282 13420240 3428 0 2472 0 0 0
+intr 1462898 0 0
+`
+
+// Build a fake procfs and point the checkers at it
+func testProc(t *testing.T, loadavg string, procs map[string]string) {
+	root := t.TempDir()
+
+	write := func(name, data string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("meminfo", testMeminfo)
+	write("stat", testStat)
+	write("loadavg", loadavg)
+	write("self/comm", "sitecheck\n")
+	for pid, comm := range procs {
+		write(filepath.Join(pid, "comm"), comm+"\n")
+	}
+
+	saved := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = saved })
+}
+
+func testDiskUsage(t *testing.T, total, avail uint64) {
+	saved := diskUsage
+	diskUsage = func(path string) (uint64, uint64, error) {
+		if path != "/var" {
+			t.Errorf("path = %q", path)
+		}
+		return total, avail, nil
+	}
+	t.Cleanup(func() { diskUsage = saved })
+}
+
+func TestDisk(t *testing.T) {
+	testDiskUsage(t, 100<<30, 20<<30)
+
+	testCheckService(t, "disk", "/var", nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != "/var 80.0% used, 20.0 GB free" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "disk", "file:///var", map[string]interface{}{"degraded_usage": 75}, degradedCheck)
+	testCheckService(t, "disk", "/var", map[string]interface{}{"degraded_usage": 50, "offline_usage": 75}, failCheck)
+}
+
+func TestDiskStatfs(t *testing.T) {
+	total, avail, err := statfsUsage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total == 0 || avail > total {
+		t.Errorf("total %d, available %d", total, avail)
+	}
+
+	testCheckService(t, "disk", "/nonexistent/path", nil, failCheck)
+}
+
+func TestMemory(t *testing.T) {
+	testProc(t, "0.50 0.75 1.00 1/100 1234\n", nil)
+
+	testCheckService(t, "memory", "localhost", nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != "memory 75.0% used, 1.9 GB available" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "memory", "localhost", map[string]interface{}{"degraded_usage": 70}, degradedCheck)
+	testCheckService(t, "memory", "localhost", map[string]interface{}{"degraded_usage": 50, "offline_usage": 70}, failCheck)
+}
+
+func TestLoad(t *testing.T) {
+	testProc(t, "2.00 6.00 1.00 1/100 1234\n", nil)
+
+	testCheckService(t, "load", "localhost", nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != "load 2.00 6.00 1.00, 4 cpus" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "load", "localhost", map[string]interface{}{"degraded_load": 2, "offline_load": 4}, successCheck)
+	testCheckService(t, "load", "localhost", map[string]interface{}{"offline_load": 1.25}, failCheck)
+}
+
+func TestProcess(t *testing.T) {
+	testProc(t, "0.00 0.00 0.00 1/100 1234\n", map[string]string{
+		"1":   "systemd",
+		"100": "nginx",
+		"101": "nginx",
+		"102": "nginx",
+		"200": "postgres",
+	})
+
+	testCheckService(t, "process", "nginx", nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != "nginx: 3 processes" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "process", "nginx", map[string]interface{}{"max": 2}, degradedCheck)
+	testCheckService(t, "process", "nginx", map[string]interface{}{"min": 4}, failCheck)
+	testCheckService(t, "process", "sitecheck", nil, failCheck)
+	testCheckService(t, "process", "redis", nil, failCheck)
+}
+
+// Command names longer than the kernel keeps match the program instead
+func TestProcessLongName(t *testing.T) {
+	testProc(t, "0.00 0.00 0.00 1/100 1234\n", map[string]string{
+		"300": "containerd-shim",
+		"301": "containerd-shim",
+		"302": "containerd-shim",
+	})
+
+	for pid, cmdline := range map[string]string{
+		"300": "/usr/bin/containerd-shim-runc-v2\x00-namespace\x00moby\x00",
+		"301": "containerd-shim-runc-v2\x00-id\x00abc\x00",
+		"302": "/usr/bin/containerd-shim\x00",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(procRoot, pid, "cmdline"), []byte(cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCheckService(t, "process", "containerd-shim-runc-v2", map[string]interface{}{"min": 2, "max": 2}, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][0].Name; name != "containerd-shim-runc-v2: 2 processes" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	testCheckService(t, "process", "containerd-shim", map[string]interface{}{"min": 3}, successCheck)
+}
+
+func TestHostMissingProc(t *testing.T) {
+	saved := procRoot
+	procRoot = filepath.Join(t.TempDir(), "missing")
+	defer func() { procRoot = saved }()
+
+	for _, typ := range []string{"memory", "load", "process"} {
+		testCheckService(t, typ, "localhost", nil, failCheck)
+	}
+}
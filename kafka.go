@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kafka struct{}
+
+const (
+	kafkaListOffsets     = 2
+	kafkaMetadata        = 3
+	kafkaOffsetFetch     = 9
+	kafkaFindCoordinator = 10
+)
+
+type kafkaGroup struct {
+	Name   string   `yaml:"name"`
+	Topics []string `yaml:"topics"`
+	MaxLag int64    `yaml:"max_lag"`
+}
+
+type kafkaOptions struct {
+	Topics []string     `yaml:"topics"`
+	Groups []kafkaGroup `yaml:"groups"`
+}
+
+// Encoder for the fixed width, non-flexible request versions we use
+type kafkaWriter struct {
+	bytes.Buffer
+}
+
+func (w *kafkaWriter) int16(v int16) { binary.Write(w, binary.BigEndian, v) }
+func (w *kafkaWriter) int32(v int32) { binary.Write(w, binary.BigEndian, v) }
+func (w *kafkaWriter) int64(v int64) { binary.Write(w, binary.BigEndian, v) }
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+// Decoder that remembers the first error so callers check only once
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = errors.New("short kafka message")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) bool() bool {
+	if b := r.next(1); b != nil {
+		return b[0] != 0
+	}
+	return false
+}
+
+// Strings and nullable strings, a null string reads as empty
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// Responses are refused beyond this, as from something that isn't Kafka
+const kafkaMaxResponse = 100 << 20
+
+type kafkaConn struct {
+	net.Conn
+	rd          *bufio.Reader
+	correlation int32
+}
+
+// Send a request and return the body of its response
+func (c *kafkaConn) call(api, version int16, body []byte) (*kafkaReader, error) {
+	c.correlation++
+
+	var req kafkaWriter
+	req.int16(api)
+	req.int16(version)
+	req.int32(c.correlation)
+	req.string("sitecheck")
+	req.Write(body)
+
+	var msg kafkaWriter
+	msg.int32(int32(req.Len()))
+	msg.Write(req.Bytes())
+
+	if _, err := c.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(c.rd, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+
+	if size < 4 || size > kafkaMaxResponse {
+		return nil, fmt.Errorf("bad response length %d", size)
+	}
+
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.rd, resp); err != nil {
+		return nil, err
+	}
+
+	r := &kafkaReader{buf: resp}
+	if id := r.int32(); id != c.correlation {
+		return nil, fmt.Errorf("correlation id %d, expected %d", id, c.correlation)
+	}
+
+	return r, nil
+}
+
+type kafkaPartition struct {
+	id       int32
+	err      int16
+	leader   int32
+	replicas int
+	isr      int
+}
+
+type kafkaMeta struct {
+	brokers map[int32]string
+	topics  map[string][]kafkaPartition
+	errors  map[string]int16 // topic error codes
+}
+
+// Describe a topic error code
+func kafkaTopicError(code int16) string {
+	switch code {
+	case 3:
+		return "unknown topic"
+	case 5:
+		return "leader not available"
+	case 29:
+		return "not authorized"
+	}
+	return fmt.Sprintf("error %d", code)
+}
+
+// Metadata v4, all topics when none are named.  Named topics that don't
+// exist must not be created by asking for them.
+func (c *kafkaConn) metadata(topics []string) (*kafkaMeta, error) {
+	var req kafkaWriter
+	if len(topics) == 0 {
+		req.int32(-1)
+	} else {
+		req.int32(int32(len(topics)))
+		for _, t := range topics {
+			req.string(t)
+		}
+	}
+	req.WriteByte(0) // allow_auto_topic_creation
+
+	r, err := c.call(kafkaMetadata, 4, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &kafkaMeta{
+		brokers: make(map[int32]string),
+		topics:  make(map[string][]kafkaPartition),
+		errors:  make(map[string]int16),
+	}
+
+	r.int32() // throttle time
+
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		meta.brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	r.string() // cluster id
+	r.int32()  // controller
+
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		code := r.int16()
+		name := r.string()
+		r.bool() // internal
+
+		partitions := make([]kafkaPartition, 0)
+		for p := r.int32(); p > 0 && r.err == nil; p-- {
+			part := kafkaPartition{
+				err:    r.int16(),
+				id:     r.int32(),
+				leader: r.int32(),
+			}
+			part.replicas = int(r.int32())
+			r.next(4 * part.replicas)
+			part.isr = int(r.int32())
+			r.next(4 * part.isr)
+			partitions = append(partitions, part)
+		}
+		meta.topics[name] = partitions
+		if code != 0 {
+			meta.errors[name] = code
+		}
+	}
+
+	return meta, r.err
+}
+
+// FindCoordinator v0, the broker managing a consumer group
+func (c *kafkaConn) coordinator(group string) (string, error) {
+	var req kafkaWriter
+	req.string(group)
+
+	r, err := c.call(kafkaFindCoordinator, 0, req.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	code := r.int16()
+	r.int32() // node id
+	host := r.string()
+	port := r.int32()
+
+	if r.err != nil {
+		return "", r.err
+	}
+	if code != 0 {
+		return "", fmt.Errorf("find coordinator error %d", code)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// ListOffsets v1, the latest offset of each partition led by this broker
+func (c *kafkaConn) latestOffsets(topic string, partitions []int32) (map[int32]int64, error) {
+	var req kafkaWriter
+	req.int32(-1) // replica id
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		req.int32(p)
+		req.int64(-1) // latest
+	}
+
+	r, err := c.call(kafkaListOffsets, 1, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64)
+
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string()
+		for p := r.int32(); p > 0 && r.err == nil; p-- {
+			id := r.int32()
+			code := r.int16()
+			r.int64() // timestamp
+			offset := r.int64()
+			if code != 0 {
+				return nil, fmt.Errorf("list offsets %s/%d error %d", topic, id, code)
+			}
+			offsets[id] = offset
+		}
+	}
+
+	return offsets, r.err
+}
+
+// OffsetFetch v1, the committed offsets of a group
+func (c *kafkaConn) committedOffsets(group, topic string, partitions []int32) (map[int32]int64, error) {
+	var req kafkaWriter
+	req.string(group)
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		req.int32(p)
+	}
+
+	r, err := c.call(kafkaOffsetFetch, 1, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64)
+
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string()
+		for p := r.int32(); p > 0 && r.err == nil; p-- {
+			id := r.int32()
+			offset := r.int64()
+			r.string() // metadata
+			code := r.int16()
+			if code != 0 {
+				return nil, fmt.Errorf("offset fetch %s/%d error %d", topic, id, code)
+			}
+			offsets[id] = offset
+		}
+	}
+
+	return offsets, r.err
+}
+
+// Connections to brokers, dialed as needed
+type kafkaCluster struct {
+	deadline  time.Time
+	conns     map[string]*kafkaConn
+	bootstrap *kafkaConn
+}
+
+func (k *kafkaCluster) conn(addr string) (*kafkaConn, error) {
+	if c, ok := k.conns[addr]; ok {
+		return c, nil
+	}
+
+	c, err := net.DialTimeout("tcp", addr, k.deadline.Sub(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetDeadline(k.deadline); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	kc := &kafkaConn{Conn: c, rd: bufio.NewReader(c)}
+	k.conns[addr] = kc
+
+	return kc, nil
+}
+
+func (k *kafkaCluster) close() {
+	for _, c := range k.conns {
+		c.Close()
+	}
+}
+
+// Total lag of a consumer group over its topics
+func (k *kafkaCluster) lag(meta *kafkaMeta, group kafkaGroup) (int64, error) {
+	addr, err := k.bootstrap.coordinator(group.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	coordinator, err := k.conn(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	var lag int64
+
+	for _, topic := range group.Topics {
+		partitions, ok := meta.topics[topic]
+		if !ok {
+			return 0, fmt.Errorf("unknown topic %s", topic)
+		}
+		if code, ok := meta.errors[topic]; ok {
+			return 0, fmt.Errorf("%s: %s", topic, kafkaTopicError(code))
+		}
+
+		// ListOffsets must be sent to each partition leader
+		leaders := make(map[int32][]int32)
+		all := make([]int32, 0)
+		for _, p := range partitions {
+			leaders[p.leader] = append(leaders[p.leader], p.id)
+			all = append(all, p.id)
+		}
+
+		latest := make(map[int32]int64)
+		for leader, ids := range leaders {
+			addr, ok := meta.brokers[leader]
+			if !ok {
+				return 0, fmt.Errorf("%s: no leader for partitions %v", topic, ids)
+			}
+			c, err := k.conn(addr)
+			if err != nil {
+				return 0, err
+			}
+			offsets, err := c.latestOffsets(topic, ids)
+			if err != nil {
+				return 0, err
+			}
+			for id, o := range offsets {
+				latest[id] = o
+			}
+		}
+
+		committed, err := coordinator.committedOffsets(group.Name, topic, all)
+		if err != nil {
+			return 0, err
+		}
+
+		for id, end := range latest {
+			// nothing committed yet, the whole log is outstanding
+			start := committed[id]
+			if start < 0 {
+				start = 0
+			}
+			if end > start {
+				lag += end - start
+			}
+		}
+	}
+
+	return lag, nil
+}
+
+func (k *Kafka) Check(srv Service) (bool, error) {
+	state, _, err := k.CheckTree(srv)
+	return state == "online", err
+}
+
+// Fetch cluster metadata from the first bootstrap broker to answer, report
+// offline and under-replicated partitions, and the lag of consumer groups
+func (k *Kafka) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &kafkaOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	cluster := &kafkaCluster{
+		deadline: time.Now().Add(time.Duration(srv.Timeout) * time.Second),
+		conns:    make(map[string]*kafkaConn),
+	}
+	defer cluster.close()
+
+	var meta *kafkaMeta
+	var err error
+
+	for _, addr := range strings.Split(strings.TrimPrefix(srv.URL, "kafka://"), ",") {
+		var c *kafkaConn
+		c, err = cluster.conn(addr)
+		if err != nil {
+			continue
+		}
+		meta, err = c.metadata(opts.Topics)
+		if err != nil {
+			continue
+		}
+		cluster.bootstrap = c
+		break
+	}
+	if meta == nil {
+		return "offline", nil, fmt.Errorf("metadata: %v", err)
+	}
+
+	children := make([]*URL, 0)
+
+	topics := make([]string, 0, len(meta.topics))
+	for topic := range meta.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		if code, ok := meta.errors[topic]; ok {
+			children = append(children, &URL{Name: topic + " " + kafkaTopicError(code), State: "offline", URL: srv.URL})
+			continue
+		}
+		for _, p := range meta.topics[topic] {
+			name := fmt.Sprintf("%s/%d", topic, p.id)
+			switch {
+			case p.leader < 0 || p.err == 5: // LEADER_NOT_AVAILABLE
+				children = append(children, &URL{Name: name + " offline", State: "offline", URL: srv.URL})
+			case p.isr < p.replicas:
+				children = append(children, &URL{
+					Name:  fmt.Sprintf("%s under-replicated (%d/%d)", name, p.isr, p.replicas),
+					State: "degraded",
+					URL:   srv.URL,
+				})
+			}
+		}
+	}
+
+	for _, g := range opts.Groups {
+		u := &URL{Name: "group/" + g.Name, State: "online", URL: srv.URL}
+
+		lag, err := cluster.lag(meta, g)
+		switch {
+		case err != nil:
+			u.State = "offline"
+			u.Name += " " + err.Error()
+		case g.MaxLag > 0 && lag > g.MaxLag:
+			u.State = "degraded"
+			u.Name += fmt.Sprintf(" lag %d", lag)
+		default:
+			u.Name += fmt.Sprintf(" lag %d", lag)
+		}
+
+		children = append(children, u)
+	}
+
+	for _, c := range children {
+		if c.State != "online" {
+			return "degraded", children, nil
+		}
+	}
+
+	return "online", children, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+)
+
+type testKafkaPartition struct {
+	leader    int32
+	replicas  int
+	isr       int
+	latest    int64
+	committed int64
+}
+
+// Single broker fake answering Metadata, FindCoordinator, ListOffsets and
+// OffsetFetch for the topic "orders".  Metadata must not ask for topics to
+// be created, other topics are unknown.
+type testKafka struct {
+	host       string
+	port       int32
+	partitions []testKafkaPartition
+}
+
+func (k *testKafka) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	k.host, k.port = host, int32(p)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go k.handle(conn)
+		}
+	}()
+
+	return "kafka://127.0.0.1:55555," + l.Addr().String()
+}
+
+func (k *testKafka) handle(conn net.Conn) {
+	defer conn.Close()
+
+	rd := bufio.NewReader(conn)
+
+	for {
+		var size int32
+		if err := binary.Read(rd, binary.BigEndian, &size); err != nil {
+			return
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return
+		}
+
+		req := &kafkaReader{buf: buf}
+		api := req.int16()
+		version := req.int16()
+		correlation := req.int32()
+		req.string() // client id
+
+		var resp kafkaWriter
+		resp.int32(correlation)
+
+		switch api {
+		case kafkaMetadata:
+			topics := []string{"orders"}
+			if n := req.int32(); n >= 0 {
+				topics = topics[:0]
+				for ; n > 0; n-- {
+					topics = append(topics, req.string())
+				}
+			}
+			if version < 4 || req.bool() {
+				return
+			}
+
+			resp.int32(0) // throttle time
+			resp.int32(1)
+			resp.int32(0)
+			resp.string(k.host)
+			resp.int32(k.port)
+			resp.int16(-1) // rack
+			resp.int16(-1) // cluster id
+			resp.int32(0)  // controller
+			resp.int32(int32(len(topics)))
+			for _, topic := range topics {
+				if topic != "orders" {
+					resp.int16(3) // UNKNOWN_TOPIC_OR_PARTITION
+					resp.string(topic)
+					resp.WriteByte(0)
+					resp.int32(0)
+					continue
+				}
+				resp.int16(0)
+				resp.string("orders")
+				resp.WriteByte(0)
+				resp.int32(int32(len(k.partitions)))
+				for i, p := range k.partitions {
+					resp.int16(0)
+					resp.int32(int32(i))
+					resp.int32(p.leader)
+					resp.int32(int32(p.replicas))
+					for r := 0; r < p.replicas; r++ {
+						resp.int32(int32(r))
+					}
+					resp.int32(int32(p.isr))
+					for r := 0; r < p.isr; r++ {
+						resp.int32(int32(r))
+					}
+				}
+			}
+		case kafkaFindCoordinator:
+			resp.int16(0)
+			resp.int32(0)
+			resp.string(k.host)
+			resp.int32(k.port)
+		case kafkaListOffsets, kafkaOffsetFetch:
+			if api == kafkaListOffsets {
+				req.int32() // replica id
+			} else {
+				req.string() // group
+			}
+			req.int32()
+			topic := req.string()
+			n := req.int32()
+
+			resp.int32(1)
+			resp.string(topic)
+			resp.int32(n)
+			for ; n > 0; n-- {
+				id := req.int32()
+				p := k.partitions[id]
+				resp.int32(id)
+				if api == kafkaListOffsets {
+					req.int64()
+					resp.int16(0)
+					resp.int64(-1)
+					resp.int64(p.latest)
+				} else {
+					resp.int64(p.committed)
+					resp.int16(-1)
+					resp.int16(0)
+				}
+			}
+		default:
+			return
+		}
+
+		var msg kafkaWriter
+		msg.int32(int32(resp.Len()))
+		msg.Write(resp.Bytes())
+		if _, err := conn.Write(msg.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func testKafkaOptions() map[string]interface{} {
+	return map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name":    "billing",
+				"topics":  []interface{}{"orders"},
+				"max_lag": 100,
+			},
+		},
+	}
+}
+
+func TestCheckStatusKafka(t *testing.T) {
+	k := &testKafka{partitions: []testKafkaPartition{
+		{leader: 0, replicas: 3, isr: 3, latest: 500, committed: 450},
+		{leader: 0, replicas: 3, isr: 3, latest: 500, committed: 490},
+	}}
+	testCheckService(t, "kafka", k.serve(t), testKafkaOptions(), func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 1 || children[0].Name != "group/billing lag 60" {
+			t.Fatalf("unexpected children %+v", children)
+		}
+	})
+}
+
+func TestCheckStatusKafkaLag(t *testing.T) {
+	k := &testKafka{partitions: []testKafkaPartition{
+		{leader: 0, replicas: 3, isr: 3, latest: 500, committed: 100},
+		{leader: 0, replicas: 3, isr: 3, latest: 500, committed: -1},
+	}}
+	testCheckService(t, "kafka", k.serve(t), testKafkaOptions(), func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 1 || children[0].State != "degraded" || children[0].Name != "group/billing lag 900" {
+			t.Fatalf("unexpected children %+v", children)
+		}
+	})
+}
+
+func TestCheckStatusKafkaPartitions(t *testing.T) {
+	k := &testKafka{partitions: []testKafkaPartition{
+		{leader: 0, replicas: 3, isr: 3},
+		{leader: 0, replicas: 3, isr: 2},
+		{leader: -1, replicas: 3, isr: 0},
+	}}
+	testCheckService(t, "kafka", k.serve(t), nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 2 {
+			t.Fatalf("expected 2 children, got %d", len(children))
+		}
+		if children[0].Name != "orders/1 under-replicated (2/3)" || children[0].State != "degraded" {
+			t.Errorf("unexpected child %+v", children[0])
+		}
+		if children[1].Name != "orders/2 offline" || children[1].State != "offline" {
+			t.Errorf("unexpected child %+v", children[1])
+		}
+	})
+}
+
+// Named topics that don't exist are offline, and never created
+func TestCheckStatusKafkaUnknownTopic(t *testing.T) {
+	k := &testKafka{partitions: []testKafkaPartition{{leader: 0, replicas: 1, isr: 1}}}
+	url := k.serve(t)
+
+	options := map[string]interface{}{
+		"topics": []interface{}{"orders", "payments"},
+		"groups": []interface{}{
+			map[string]interface{}{"name": "billing", "topics": []interface{}{"payments"}},
+		},
+	}
+
+	testCheckService(t, "kafka", url, options, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 2 {
+			t.Fatalf("children = %d, expected 2", len(children))
+		}
+		if children[0].Name != "payments unknown topic" || children[0].State != "offline" {
+			t.Errorf("unexpected child %+v", children[0])
+		}
+		if children[1].Name != "group/billing payments: unknown topic" || children[1].State != "offline" {
+			t.Errorf("unexpected child %+v", children[1])
+		}
+	})
+}
+
+func TestCheckStatusKafkaMissing(t *testing.T) {
+	testCheckService(t, "kafka", "kafka://127.0.0.1:55555", nil, failCheck)
+}
+
+// Something that isn't Kafka answering with a nonsense length
+func TestCheckStatusKafkaBadLength(t *testing.T) {
+	for _, size := range []uint32{0xfffffff0, 0x48545450, 2} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		go func(size uint32) {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			binary.Write(conn, binary.BigEndian, size)
+			io.Copy(ioutil.Discard, conn)
+		}(size)
+
+		testCheckService(t, "kafka", "kafka://"+l.Addr().String(), nil, failCheck)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Kubernetes struct{}
+
+type kubernetesOptions struct {
+	Token       string `yaml:"token"`
+	TokenFile   string `yaml:"token_file"`
+	Kubeconfig  string `yaml:"kubeconfig"`
+	Context     string `yaml:"context"`
+	Namespace   string `yaml:"namespace"`
+	Nodes       bool   `yaml:"nodes"`
+	Deployments bool   `yaml:"deployments"`
+	tlsOptions  `yaml:",inline"`
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// Read inline data if present, otherwise the named file
+func kubeData(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// Apply the credentials and cluster settings of a kubeconfig context
+func (k *kubeClient) loadKubeconfig(opts *kubernetesOptions, cfg *tls.Config) error {
+	data, err := ioutil.ReadFile(opts.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	kc := &kubeconfig{}
+	if err := yaml.Unmarshal(data, kc); err != nil {
+		return err
+	}
+
+	name := opts.Context
+	if name == "" {
+		name = kc.CurrentContext
+	}
+
+	var cluster, user string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == name {
+			cluster, user = c.Context.Cluster, c.Context.User
+			if opts.Namespace == "" {
+				opts.Namespace = c.Context.Namespace
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("context %q not found", name)
+	}
+
+	for _, c := range kc.Clusters {
+		if c.Name != cluster {
+			continue
+		}
+		cfg.InsecureSkipVerify = cfg.InsecureSkipVerify || c.Cluster.InsecureSkipTLSVerify
+		ca, err := kubeData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority)
+		if err != nil {
+			return err
+		}
+		if ca != nil {
+			cfg.RootCAs = x509.NewCertPool()
+			cfg.RootCAs.AppendCertsFromPEM(ca)
+		}
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != user {
+			continue
+		}
+		if k.token == "" {
+			k.token = u.User.Token
+		}
+		if k.token == "" && u.User.TokenFile != "" {
+			b, err := ioutil.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return err
+			}
+			k.token = strings.TrimSpace(string(b))
+		}
+		cert, err := kubeData(u.User.ClientCertificateData, u.User.ClientCertificate)
+		if err != nil {
+			return err
+		}
+		key, err := kubeData(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return err
+			}
+			cfg.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	return nil
+}
+
+func (k *kubeClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, k.server+path, nil)
+	if err != nil {
+		return fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: response status %d", path, resp.StatusCode)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	return nil
+}
+
+// Nodes whose Ready condition is not True
+func (k *kubeClient) notReadyNodes() ([]*URL, error) {
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+
+	if err := k.get("/api/v1/nodes", &list); err != nil {
+		return nil, err
+	}
+
+	urls := make([]*URL, 0)
+
+	for _, n := range list.Items {
+		ready := false
+		for _, c := range n.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready = true
+			}
+		}
+		if !ready {
+			urls = append(urls, &URL{
+				Name:  "node/" + n.Metadata.Name,
+				State: "offline",
+				URL:   k.server + "/api/v1/nodes/" + n.Metadata.Name,
+			})
+		}
+	}
+
+	return urls, nil
+}
+
+// Deployments with fewer available replicas than desired
+func (k *kubeClient) unavailableDeployments(namespace string) ([]*URL, error) {
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				Replicas *int `json:"replicas"`
+			} `json:"spec"`
+			Status struct {
+				AvailableReplicas   int `json:"availableReplicas"`
+				UnavailableReplicas int `json:"unavailableReplicas"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+
+	path := "/apis/apps/v1/deployments"
+	if namespace != "" {
+		path = "/apis/apps/v1/namespaces/" + namespace + "/deployments"
+	}
+
+	if err := k.get(path, &list); err != nil {
+		return nil, err
+	}
+
+	urls := make([]*URL, 0)
+
+	for _, d := range list.Items {
+		desired := 1
+		if d.Spec.Replicas != nil {
+			desired = *d.Spec.Replicas
+		}
+
+		if d.Status.UnavailableReplicas == 0 && d.Status.AvailableReplicas >= desired {
+			continue
+		}
+
+		state := "degraded"
+		if d.Status.AvailableReplicas == 0 {
+			state = "offline"
+		}
+
+		urls = append(urls, &URL{
+			Name:  fmt.Sprintf("deployment/%s/%s (%d/%d)", d.Metadata.Namespace, d.Metadata.Name, d.Status.AvailableReplicas, desired),
+			State: state,
+			URL:   k.server + "/apis/apps/v1/namespaces/" + d.Metadata.Namespace + "/deployments/" + d.Metadata.Name,
+		})
+	}
+
+	return urls, nil
+}
+
+func (k *Kubernetes) Check(srv Service) (bool, error) {
+	state, _, err := k.CheckTree(srv)
+	return state == "online", err
+}
+
+// Probe the API server and report unhealthy nodes and deployments as
+// children of the cluster
+func (k *Kubernetes) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &kubernetesOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return "offline", nil, fmt.Errorf("tls: %v", err)
+	}
+
+	kc := &kubeClient{
+		server: strings.TrimRight(srv.URL, "/"),
+		token:  opts.Token,
+	}
+
+	if kc.token == "" && opts.TokenFile != "" {
+		b, err := ioutil.ReadFile(opts.TokenFile)
+		if err != nil {
+			return "offline", nil, err
+		}
+		kc.token = strings.TrimSpace(string(b))
+	}
+
+	if opts.Kubeconfig != "" {
+		opts.Kubeconfig = os.ExpandEnv(opts.Kubeconfig)
+		if err := kc.loadKubeconfig(opts, cfg); err != nil {
+			return "offline", nil, fmt.Errorf("kubeconfig: %v", err)
+		}
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	kc.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: cfg},
+	}
+
+	for _, probe := range []string{"/livez", "/readyz"} {
+		if err := kc.get(probe, nil); err != nil {
+			return "offline", nil, err
+		}
+	}
+
+	children := make([]*URL, 0)
+
+	if opts.Nodes {
+		nodes, err := kc.notReadyNodes()
+		if err != nil {
+			return "degraded", nil, err
+		}
+		children = append(children, nodes...)
+	}
+
+	if opts.Deployments {
+		deployments, err := kc.unavailableDeployments(opts.Namespace)
+		if err != nil {
+			return "degraded", children, err
+		}
+		children = append(children, deployments...)
+	}
+
+	if len(children) > 0 {
+		return "degraded", children, nil
+	}
+
+	return "online", children, nil
+}
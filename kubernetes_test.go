@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeNodes = `{"items": [
+	{"metadata": {"name": "node1"}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}},
+	{"metadata": {"name": "node2"}, "status": {"conditions": [{"type": "Ready", "status": "%s"}]}}
+]}`
+
+const testKubeDeployments = `{"items": [
+	{"metadata": {"name": "web", "namespace": "shop"}, "spec": {"replicas": 3}, "status": {"availableReplicas": 3}},
+	{"metadata": {"name": "cart", "namespace": "shop"}, "spec": {"replicas": 2}, "status": {"availableReplicas": %d, "unavailableReplicas": %d}}
+]}`
+
+// Fake API server requiring a bearer token
+type testKubeAPI struct {
+	readyz      int
+	nodeReady   string
+	unavailable int
+}
+
+func (k *testKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer sekrit" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/livez":
+		fmt.Fprint(w, "ok")
+	case "/readyz":
+		if k.readyz != 0 {
+			http.Error(w, "not ready", k.readyz)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	case "/api/v1/nodes":
+		fmt.Fprintf(w, testKubeNodes, k.nodeReady)
+	case "/apis/apps/v1/namespaces/shop/deployments":
+		fmt.Fprintf(w, testKubeDeployments, 2-k.unavailable, k.unavailable)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testKubeServer(t *testing.T, api *testKubeAPI) string {
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func testKubeOptions() map[string]interface{} {
+	return map[string]interface{}{
+		"token":       "sekrit",
+		"namespace":   "shop",
+		"nodes":       true,
+		"deployments": true,
+	}
+}
+
+func TestCheckStatusKubernetes(t *testing.T) {
+	url := testKubeServer(t, &testKubeAPI{nodeReady: "True"})
+	testCheckService(t, "kubernetes", url, testKubeOptions(), func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		if len(cfg[0].children[0]) != 0 {
+			t.Fatalf("expected no children, got %d", len(cfg[0].children[0]))
+		}
+	})
+}
+
+func TestCheckStatusKubernetesUnauthorized(t *testing.T) {
+	url := testKubeServer(t, &testKubeAPI{nodeReady: "True"})
+	testCheckService(t, "kubernetes", url, nil, failCheck)
+}
+
+func TestCheckStatusKubernetesNotReady(t *testing.T) {
+	url := testKubeServer(t, &testKubeAPI{readyz: http.StatusInternalServerError, nodeReady: "True"})
+	testCheckService(t, "kubernetes", url, testKubeOptions(), failCheck)
+}
+
+func TestCheckStatusKubernetesNodeNotReady(t *testing.T) {
+	url := testKubeServer(t, &testKubeAPI{nodeReady: "Unknown"})
+	testCheckService(t, "kubernetes", url, testKubeOptions(), func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 1 {
+			t.Fatalf("expected 1 child, got %d", len(children))
+		}
+		if children[0].Name != "node/node2" || children[0].State != "offline" {
+			t.Fatalf("unexpected child %s %s", children[0].Name, children[0].State)
+		}
+	})
+}
+
+func TestCheckStatusKubernetesDeployment(t *testing.T) {
+	for _, tc := range []struct {
+		unavailable int
+		state       string
+	}{
+		{1, "degraded"},
+		{2, "offline"},
+	} {
+		url := testKubeServer(t, &testKubeAPI{nodeReady: "True", unavailable: tc.unavailable})
+		testCheckService(t, "kubernetes", url, testKubeOptions(), func(t *testing.T, cfg []*Config) {
+			degradedCheck(t, cfg)
+			children := cfg[0].children[0]
+			if len(children) != 1 {
+				t.Fatalf("expected 1 child, got %d", len(children))
+			}
+			if children[0].State != tc.state {
+				t.Fatalf("deployment %s state %s, expected %s", children[0].Name, children[0].State, tc.state)
+			}
+		})
+	}
+}
+
+func TestCheckStatusKubernetesKubeconfig(t *testing.T) {
+	url := testKubeServer(t, &testKubeAPI{nodeReady: "True"})
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(kubeconfig, []byte(`
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: `+url+`
+users:
+- name: sitecheck
+  user:
+    token: sekrit
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: sitecheck
+    namespace: shop
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := map[string]interface{}{
+		"kubeconfig":  kubeconfig,
+		"deployments": true,
+	}
+	testCheckService(t, "kubernetes", url, opts, successCheck)
+}
+
+func TestCheckStatusKubernetesMissing(t *testing.T) {
+	testCheckService(t, "kubernetes", "http://127.0.0.1:55555", nil, failCheck)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type LDAP struct{}
+
+type ldapOptions struct {
+	StartTLS   bool   `yaml:"start_tls"`
+	BindDN     string `yaml:"bind_dn"`
+	Password   string `yaml:"password"`
+	BaseDN     string `yaml:"base_dn"`
+	Filter     string `yaml:"filter"`
+	Attribute  string `yaml:"attribute"`
+	Value      string `yaml:"value"`
+	tlsOptions `yaml:",inline"`
+}
+
+// Bind to the directory and optionally read a single entry
+func (l *LDAP) Check(srv Service) (bool, error) {
+	opts := &ldapOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return false, fmt.Errorf("options: %v", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		return false, err
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return false, fmt.Errorf("tls: %v", err)
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+
+	conn, err := ldap.DialURL(srv.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(cfg))
+	if err != nil {
+		return false, fmt.Errorf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(timeout)
+
+	if opts.StartTLS {
+		if err := conn.StartTLS(cfg); err != nil {
+			return false, fmt.Errorf("starttls: %v", err)
+		}
+	}
+
+	if opts.Password != "" {
+		err = conn.Bind(opts.BindDN, opts.Password)
+	} else {
+		err = conn.UnauthenticatedBind(opts.BindDN)
+	}
+	if err != nil {
+		return false, fmt.Errorf("bind: %v", err)
+	}
+
+	if opts.BaseDN == "" {
+		return true, nil
+	}
+
+	filter := opts.Filter
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+
+	var attrs []string
+	if opts.Attribute != "" {
+		attrs = []string{opts.Attribute}
+	}
+
+	req := ldap.NewSearchRequest(opts.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, srv.Timeout, false, filter, attrs, nil)
+
+	result, err := conn.Search(req)
+	if err != nil {
+		return false, fmt.Errorf("search: %v", err)
+	}
+
+	if len(result.Entries) == 0 {
+		return false, fmt.Errorf("%s not found", opts.BaseDN)
+	}
+
+	if opts.Attribute == "" {
+		return true, nil
+	}
+
+	values := result.Entries[0].GetAttributeValues(opts.Attribute)
+	if len(values) == 0 {
+		return false, fmt.Errorf("%s has no attribute %s", opts.BaseDN, opts.Attribute)
+	}
+
+	if opts.Value == "" {
+		return true, nil
+	}
+
+	for _, v := range values {
+		if v == opts.Value {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("%s: %s is not %s", opts.BaseDN, opts.Attribute, opts.Value)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Minimal directory: a single entry, an optional bind password and StartTLS
+type testDirectory struct {
+	dn       string
+	attrs    map[string]string
+	password string
+	tls      *tls.Config
+}
+
+func (d *testDirectory) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+
+	return "ldap://" + l.Addr().String()
+}
+
+func testLDAPResult(id int64, op ber.Tag, code int) *ber.Packet {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	msg.AppendChild(res)
+
+	return msg
+}
+
+func (d *testDirectory) entry(id int64) *ber.Packet {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, d.dn, "objectName"))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for k, v := range d.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	entry.AppendChild(attrs)
+	msg.AppendChild(entry)
+
+	return msg
+}
+
+func (d *testDirectory) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+
+		id := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+
+		var reply []*ber.Packet
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if d.password != "" && op.Children[2].Data.String() != d.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			reply = append(reply, testLDAPResult(id, ldap.ApplicationBindResponse, int(code)))
+		case ldap.ApplicationSearchRequest:
+			code := ldap.LDAPResultNoSuchObject
+			if op.Children[0].Value.(string) == d.dn {
+				reply = append(reply, d.entry(id))
+				code = ldap.LDAPResultSuccess
+			}
+			reply = append(reply, testLDAPResult(id, ldap.ApplicationSearchResultDone, int(code)))
+		case ldap.ApplicationExtendedRequest:
+			if d.tls == nil {
+				reply = append(reply, testLDAPResult(id, ldap.ApplicationExtendedResponse, int(ldap.LDAPResultProtocolError)))
+				break
+			}
+			conn.Write(testLDAPResult(id, ldap.ApplicationExtendedResponse, int(ldap.LDAPResultSuccess)).Bytes())
+			conn = tls.Server(conn, d.tls)
+			continue
+		default:
+			return
+		}
+
+		for _, r := range reply {
+			if _, err := conn.Write(r.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func testLDAPDirectory() *testDirectory {
+	return &testDirectory{
+		dn: "uid=probe,ou=people,dc=foo,dc=com",
+		attrs: map[string]string{
+			"mail": "probe@foo.com",
+		},
+	}
+}
+
+func TestCheckStatusLDAPAnonymous(t *testing.T) {
+	d := testLDAPDirectory()
+	testCheckService(t, "ldap", d.serve(t), nil, successCheck)
+}
+
+func TestCheckStatusLDAPBind(t *testing.T) {
+	d := testLDAPDirectory()
+	d.password = "sekrit"
+	opts := map[string]interface{}{
+		"bind_dn":  "cn=sitecheck,dc=foo,dc=com",
+		"password": "sekrit",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, successCheck)
+}
+
+func TestCheckStatusLDAPBadPassword(t *testing.T) {
+	d := testLDAPDirectory()
+	d.password = "sekrit"
+	opts := map[string]interface{}{
+		"bind_dn":  "cn=sitecheck,dc=foo,dc=com",
+		"password": "fumble",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, failCheck)
+}
+
+func TestCheckStatusLDAPSearch(t *testing.T) {
+	d := testLDAPDirectory()
+	opts := map[string]interface{}{
+		"base_dn":   "uid=probe,ou=people,dc=foo,dc=com",
+		"attribute": "mail",
+		"value":     "probe@foo.com",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, successCheck)
+}
+
+func TestCheckStatusLDAPSearchWrongValue(t *testing.T) {
+	d := testLDAPDirectory()
+	opts := map[string]interface{}{
+		"base_dn":   "uid=probe,ou=people,dc=foo,dc=com",
+		"attribute": "mail",
+		"value":     "fumble@foo.com",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, failCheck)
+}
+
+func TestCheckStatusLDAPSearchNoAttribute(t *testing.T) {
+	d := testLDAPDirectory()
+	opts := map[string]interface{}{
+		"base_dn":   "uid=probe,ou=people,dc=foo,dc=com",
+		"attribute": "telephoneNumber",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, failCheck)
+}
+
+func TestCheckStatusLDAPSearchNoEntry(t *testing.T) {
+	d := testLDAPDirectory()
+	opts := map[string]interface{}{
+		"base_dn": "uid=fumble,ou=people,dc=foo,dc=com",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, failCheck)
+}
+
+func TestCheckStatusLDAPStartTLS(t *testing.T) {
+	// borrow the test certificate from httptest
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+
+	d := testLDAPDirectory()
+	d.tls = &tls.Config{Certificates: ts.TLS.Certificates}
+	opts := map[string]interface{}{
+		"start_tls":            true,
+		"insecure_skip_verify": true,
+		"base_dn":              "uid=probe,ou=people,dc=foo,dc=com",
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, successCheck)
+}
+
+func TestCheckStatusLDAPStartTLSUnsupported(t *testing.T) {
+	d := testLDAPDirectory()
+	opts := map[string]interface{}{
+		"start_tls":            true,
+		"insecure_skip_verify": true,
+	}
+	testCheckService(t, "ldap", d.serve(t), opts, failCheck)
+}
+
+func TestCheckStatusLDAPMissing(t *testing.T) {
+	testCheckService(t, "ldap", "ldap://127.0.0.1:55555", nil, failCheck)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type MQTT struct{}
+
+const (
+	mqttCONNECT    = 1
+	mqttCONNACK    = 2
+	mqttPUBLISH    = 3
+	mqttSUBSCRIBE  = 8
+	mqttSUBACK     = 9
+	mqttDISCONNECT = 14
+)
+
+type mqttOptions struct {
+	Version    int           `yaml:"version"`
+	ClientID   string        `yaml:"client_id"`
+	Username   string        `yaml:"username"`
+	Password   string        `yaml:"password"`
+	Topic      string        `yaml:"topic"`
+	MaxRTT     time.Duration `yaml:"max_rtt"`
+	tlsOptions `yaml:",inline"`
+}
+
+type mqttConn struct {
+	net.Conn
+	rd      *bufio.Reader
+	version int
+}
+
+func mqttString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func mqttVarint(buf *bytes.Buffer, n int) {
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func readVarint(rd io.ByteReader) (int, error) {
+	n := 0
+	for shift := uint(0); shift < 28; shift += 7 {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("malformed remaining length")
+}
+
+func (c *mqttConn) send(header byte, body []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(header)
+	mqttVarint(&buf, len(body))
+	buf.Write(body)
+	_, err := c.Write(buf.Bytes())
+	return err
+}
+
+func (c *mqttConn) recv() (byte, []byte, error) {
+	header, err := c.rd.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n, err := readVarint(c.rd)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.rd, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
+
+// Read packets until one of the wanted type arrives
+func (c *mqttConn) expect(packet byte) (byte, []byte, error) {
+	for {
+		header, body, err := c.recv()
+		if err != nil {
+			return 0, nil, err
+		}
+		if header>>4 == packet {
+			return header, body, nil
+		}
+	}
+}
+
+func (c *mqttConn) connect(opts *mqttOptions) error {
+	var buf bytes.Buffer
+
+	flags := byte(0x02) // clean session
+	if opts.Username != "" {
+		flags |= 0x80
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+	}
+
+	mqttString(&buf, "MQTT")
+	buf.WriteByte(byte(c.version))
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, uint16(60))
+	if c.version == 5 {
+		mqttVarint(&buf, 0)
+	}
+
+	mqttString(&buf, opts.ClientID)
+	if opts.Username != "" {
+		mqttString(&buf, opts.Username)
+	}
+	if opts.Password != "" {
+		mqttString(&buf, opts.Password)
+	}
+
+	if err := c.send(mqttCONNECT<<4, buf.Bytes()); err != nil {
+		return err
+	}
+
+	_, body, err := c.expect(mqttCONNACK)
+	if err != nil {
+		return err
+	}
+
+	if len(body) < 2 {
+		return errors.New("short CONNACK")
+	}
+
+	if body[1] != 0 {
+		return fmt.Errorf("connection refused, code %d", body[1])
+	}
+
+	return nil
+}
+
+func (c *mqttConn) subscribe(topic string) error {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	if c.version == 5 {
+		mqttVarint(&buf, 0)
+	}
+	mqttString(&buf, topic)
+	buf.WriteByte(0) // QoS 0
+
+	if err := c.send(mqttSUBSCRIBE<<4|0x02, buf.Bytes()); err != nil {
+		return err
+	}
+
+	_, body, err := c.expect(mqttSUBACK)
+	if err != nil {
+		return err
+	}
+
+	rd := bytes.NewReader(body)
+	rd.Seek(2, io.SeekStart)
+	if c.version == 5 {
+		n, err := readVarint(rd)
+		if err != nil {
+			return err
+		}
+		rd.Seek(int64(n), io.SeekCurrent)
+	}
+
+	code, err := rd.ReadByte()
+	if err != nil {
+		return errors.New("short SUBACK")
+	}
+
+	if code >= 0x80 {
+		return fmt.Errorf("subscribe refused, code %d", code)
+	}
+
+	return nil
+}
+
+func (c *mqttConn) publish(topic, msg string) error {
+	var buf bytes.Buffer
+
+	mqttString(&buf, topic)
+	if c.version == 5 {
+		mqttVarint(&buf, 0)
+	}
+	buf.WriteString(msg)
+
+	return c.send(mqttPUBLISH<<4, buf.Bytes())
+}
+
+// Wait for msg to be delivered on the subscribed topic
+func (c *mqttConn) delivered(msg string) error {
+	for {
+		header, body, err := c.expect(mqttPUBLISH)
+		if err != nil {
+			return err
+		}
+
+		rd := bytes.NewReader(body)
+
+		var n uint16
+		if err := binary.Read(rd, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		rd.Seek(int64(n), io.SeekCurrent)
+
+		if header&0x06 != 0 {
+			rd.Seek(2, io.SeekCurrent) // packet identifier
+		}
+
+		if c.version == 5 {
+			n, err := readVarint(rd)
+			if err != nil {
+				return err
+			}
+			rd.Seek(int64(n), io.SeekCurrent)
+		}
+
+		payload, _ := ioutil.ReadAll(rd)
+		if string(payload) == msg {
+			return nil
+		}
+	}
+}
+
+func (m *MQTT) Check(srv Service) (bool, error) {
+	state, _, err := m.CheckTree(srv)
+	return state == "online", err
+}
+
+// Connect to the broker and, when a topic is configured, measure the time
+// taken for a published probe to be delivered back to us, shown as a child
+func (m *MQTT) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &mqttOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	version := 4
+	switch opts.Version {
+	case 0, 3, 4:
+	case 5:
+		version = 5
+	default:
+		return "offline", nil, fmt.Errorf("unsupported MQTT version %d", opts.Version)
+	}
+
+	// MQTT 3.1.1 only allows a password along with a user name
+	if version == 4 && opts.Password != "" && opts.Username == "" {
+		return "offline", nil, errors.New("password without username")
+	}
+
+	if opts.ClientID == "" {
+		opts.ClientID = "sitecheck-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "mqtts" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	}
+
+	deadline := time.Now().Add(time.Duration(srv.Timeout) * time.Second)
+
+	conn, err := net.DialTimeout("tcp", host, deadline.Sub(time.Now()))
+	if err != nil {
+		return "offline", nil, err
+	}
+	defer conn.Close()
+
+	if u.Scheme == "mqtts" || opts.TLS {
+		cfg, err := opts.config()
+		if err != nil {
+			return "offline", nil, fmt.Errorf("tls: %v", err)
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, cfg)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "offline", nil, err
+	}
+
+	c := &mqttConn{Conn: conn, rd: bufio.NewReader(conn), version: version}
+
+	if err := c.connect(opts); err != nil {
+		return "offline", nil, fmt.Errorf("connect: %v", err)
+	}
+	defer c.send(mqttDISCONNECT<<4, nil)
+
+	if opts.Topic == "" {
+		return "online", nil, nil
+	}
+
+	if err := c.subscribe(opts.Topic); err != nil {
+		return "offline", nil, fmt.Errorf("subscribe: %v", err)
+	}
+
+	probe := opts.ClientID + " " + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	start := time.Now()
+
+	if err := c.publish(opts.Topic, probe); err != nil {
+		return "offline", nil, fmt.Errorf("publish: %v", err)
+	}
+
+	if err := c.delivered(probe); err != nil {
+		return "offline", nil, fmt.Errorf("delivery: %v", err)
+	}
+
+	rtt := time.Since(start)
+
+	child := &URL{
+		Name:  fmt.Sprintf("%s round trip %s", opts.Topic, rtt.Round(time.Millisecond)),
+		State: "online",
+		URL:   srv.URL,
+	}
+	children := []*URL{child}
+
+	if opts.MaxRTT > 0 && rtt > opts.MaxRTT {
+		child.State = "degraded"
+		return "degraded", children, fmt.Errorf("round trip %v exceeds %v", rtt, opts.MaxRTT)
+	}
+
+	return "online", children, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Minimal in-process broker: accepts every connection, acknowledges
+// subscriptions and delivers publishes back to the publishing client.
+type testBroker struct {
+	refuse  byte
+	deliver bool
+	delay   time.Duration
+}
+
+func (b *testBroker) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.handle(conn)
+		}
+	}()
+
+	return "mqtt://" + l.Addr().String()
+}
+
+func (b *testBroker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	c := &mqttConn{Conn: conn, rd: bufio.NewReader(conn)}
+
+	for {
+		header, body, err := c.recv()
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case mqttCONNECT:
+			c.version = int(body[6])
+			ack := []byte{0, b.refuse}
+			if c.version == 5 {
+				ack = append(ack, 0)
+			}
+			c.send(mqttCONNACK<<4, ack)
+		case mqttSUBSCRIBE:
+			ack := []byte{body[0], body[1]}
+			if c.version == 5 {
+				ack = append(ack, 0)
+			}
+			c.send(mqttSUBACK<<4, append(ack, 0))
+		case mqttPUBLISH:
+			if b.deliver {
+				time.Sleep(b.delay)
+				c.send(header, body)
+			}
+		case mqttDISCONNECT:
+			return
+		}
+	}
+}
+
+func TestCheckStatusMQTTConnect(t *testing.T) {
+	b := &testBroker{}
+	testCheckService(t, "mqtt", b.serve(t), nil, successCheck)
+}
+
+func TestCheckStatusMQTTRefused(t *testing.T) {
+	b := &testBroker{refuse: 5}
+	testCheckService(t, "mqtt", b.serve(t), nil, failCheck)
+}
+
+func TestCheckStatusMQTTRoundTrip(t *testing.T) {
+	b := &testBroker{deliver: true}
+	testCheckService(t, "mqtt", b.serve(t), map[string]interface{}{"topic": "sitecheck/probe"}, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 1 || !strings.HasPrefix(children[0].Name, "sitecheck/probe round trip ") || children[0].State != "online" {
+			t.Errorf("children = %+v", children)
+		}
+	})
+}
+
+// MQTT 3.1.1 forbids a password without a user name, MQTT 5 allows it
+func TestCheckStatusMQTTPasswordOnly(t *testing.T) {
+	b := &testBroker{}
+	url := b.serve(t)
+
+	testCheckService(t, "mqtt", url, map[string]interface{}{"password": "sekrit"}, failCheck)
+	testCheckService(t, "mqtt", url, map[string]interface{}{"version": 5, "password": "sekrit"}, successCheck)
+}
+
+func TestCheckStatusMQTTv5RoundTrip(t *testing.T) {
+	b := &testBroker{deliver: true}
+	opts := map[string]interface{}{
+		"version":  5,
+		"topic":    "sitecheck/probe",
+		"username": "fumble",
+		"password": "sekrit",
+	}
+	testCheckService(t, "mqtt", b.serve(t), opts, successCheck)
+}
+
+func TestCheckStatusMQTTSlow(t *testing.T) {
+	b := &testBroker{deliver: true, delay: 50 * time.Millisecond}
+	opts := map[string]interface{}{
+		"topic":   "sitecheck/probe",
+		"max_rtt": "10ms",
+	}
+	testCheckService(t, "mqtt", b.serve(t), opts, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		if c := cfg[0].children[0][0]; c.State != "degraded" {
+			t.Errorf("child = %s %s", c.Name, c.State)
+		}
+	})
+}
+
+func TestCheckStatusMQTTNotDelivered(t *testing.T) {
+	b := &testBroker{}
+	url := b.serve(t)
+
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    "mqtt",
+		URL:     []string{url},
+		Options: map[string]interface{}{"topic": "sitecheck/probe"},
+		state:   []string{"unknown"},
+		Timeout: 1,
+	}}
+
+	s := &server{cfg: cfg}
+
+	s.refresh(Wait)
+
+	failCheck(t, cfg)
+}
+
+func TestCheckStatusMQTTMissing(t *testing.T) {
+	testCheckService(t, "mqtt", "mqtt://127.0.0.1:55555", nil, failCheck)
+}
+
+func TestMQTTVarint(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 268435455} {
+		var buf bytes.Buffer
+		mqttVarint(&buf, n)
+		got, err := readVarint(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != n {
+			t.Errorf("varint %d decoded as %d", n, got)
+		}
+	}
+}
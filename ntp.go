@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+type NTP struct{}
+
+type ntpOptions struct {
+	DegradedOffset time.Duration `yaml:"degraded_offset"`
+	OfflineOffset  time.Duration `yaml:"offline_offset"`
+}
+
+// seconds between the NTP era (1900) and the unix epoch
+const ntpEpoch = 2208988800
+
+type ntpPacket struct {
+	Settings       uint8
+	Stratum        uint8
+	Poll           int8
+	Precision      int8
+	RootDelay      uint32
+	RootDispersion uint32
+	ReferenceID    uint32
+	RefTime        uint64
+	OrigTime       uint64
+	RxTime         uint64
+	TxTime         uint64
+}
+
+func ntpTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+func ntpToTime(ts uint64) time.Time {
+	sec := int64(ts>>32) - ntpEpoch
+	nsec := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(sec, nsec)
+}
+
+// Query the server and return its stratum and our clock offset from it
+func ntpQuery(addr string, timeout time.Duration) (uint8, time.Duration, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, 0, err
+	}
+
+	req := &ntpPacket{Settings: 0x23} // LI 0, version 4, client mode
+
+	t1 := time.Now()
+	req.TxTime = ntpTime(t1)
+
+	if err := binary.Write(conn, binary.BigEndian, req); err != nil {
+		return 0, 0, fmt.Errorf("send: %v", err)
+	}
+
+	resp := &ntpPacket{}
+	if err := binary.Read(conn, binary.BigEndian, resp); err != nil {
+		return 0, 0, fmt.Errorf("receive: %v", err)
+	}
+
+	t4 := time.Now()
+
+	if resp.OrigTime != req.TxTime {
+		return 0, 0, errors.New("reply does not match request")
+	}
+
+	if resp.Settings>>6 == 3 {
+		return 16, 0, nil // alarm condition, clock not synchronised
+	}
+
+	t2 := ntpToTime(resp.RxTime)
+	t3 := ntpToTime(resp.TxTime)
+
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+
+	return resp.Stratum, offset, nil
+}
+
+func (n *NTP) Check(srv Service) (bool, error) {
+	state, err := n.CheckState(srv)
+	return state == "online", err
+}
+
+// Compare our clock with the server, a server that is itself unsynchronised
+// is offline
+func (n *NTP) CheckState(srv Service) (string, error) {
+	opts := &ntpOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", fmt.Errorf("options: %v", err)
+	}
+
+	addr := strings.TrimPrefix(srv.URL, "ntp://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "123")
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+
+	stratum, offset, err := ntpQuery(addr, timeout)
+	if err != nil {
+		return "offline", err
+	}
+
+	if stratum == 0 || stratum >= 16 {
+		return "offline", fmt.Errorf("unsynchronised, stratum %d", stratum)
+	}
+
+	if offset < 0 {
+		offset = -offset
+	}
+
+	if opts.OfflineOffset > 0 && offset > opts.OfflineOffset {
+		return "offline", fmt.Errorf("offset %v, stratum %d", offset, stratum)
+	}
+
+	if opts.DegradedOffset > 0 && offset > opts.DegradedOffset {
+		log.Printf("ntp %s: offset %v, stratum %d\n", srv.URL, offset, stratum)
+		return "degraded", nil
+	}
+
+	return "online", nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Answer NTP requests with a clock skewed by the given amount
+func testNTPServer(t *testing.T, stratum uint8, skew time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+
+			now := ntpTime(time.Now().Add(skew))
+
+			resp := make([]byte, 48)
+			resp[0] = 0x24 // version 4, server mode
+			resp[1] = stratum
+			binary.BigEndian.PutUint64(resp[24:], binary.BigEndian.Uint64(buf[40:]))
+			binary.BigEndian.PutUint64(resp[32:], now)
+			binary.BigEndian.PutUint64(resp[40:], now)
+
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return "ntp://" + conn.LocalAddr().String()
+}
+
+func TestCheckStatusNTP(t *testing.T) {
+	url := testNTPServer(t, 2, 0)
+	opts := map[string]interface{}{
+		"degraded_offset": "1s",
+		"offline_offset":  "5s",
+	}
+	testCheckService(t, "ntp", url, opts, successCheck)
+}
+
+func TestCheckStatusNTPDegraded(t *testing.T) {
+	url := testNTPServer(t, 2, 2*time.Second)
+	opts := map[string]interface{}{
+		"degraded_offset": "1s",
+		"offline_offset":  "5s",
+	}
+	testCheckService(t, "ntp", url, opts, degradedCheck)
+}
+
+func TestCheckStatusNTPOffline(t *testing.T) {
+	url := testNTPServer(t, 2, -10*time.Second)
+	opts := map[string]interface{}{
+		"degraded_offset": "1s",
+		"offline_offset":  "5s",
+	}
+	testCheckService(t, "ntp", url, opts, failCheck)
+}
+
+func TestCheckStatusNTPUnsynchronised(t *testing.T) {
+	url := testNTPServer(t, 16, 0)
+	testCheckService(t, "ntp", url, nil, failCheck)
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Now()
+	if d := ntpToTime(ntpTime(now)).Sub(now); d > time.Microsecond || d < -time.Microsecond {
+		t.Fatalf("round trip through NTP time off by %v", d)
+	}
+}
+
+func TestCheckStatusNTPMissing(t *testing.T) {
+	testCheckService(t, "ntp", "ntp://127.0.0.1:55555", nil, failCheck)
+}
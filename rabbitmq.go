@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type RabbitMQ struct{}
+
+type rabbitmqQueue struct {
+	Name         string `yaml:"name"`
+	VHost        string `yaml:"vhost"`
+	MaxMessages  int    `yaml:"max_messages"`
+	MinConsumers int    `yaml:"min_consumers"`
+}
+
+type rabbitmqOptions struct {
+	Username   string          `yaml:"username"`
+	Password   string          `yaml:"password"`
+	Queues     []rabbitmqQueue `yaml:"queues"`
+	tlsOptions `yaml:",inline"`
+}
+
+type rabbitmqClient struct {
+	base     string
+	username string
+	password string
+	client   *http.Client
+}
+
+// GET a management API path, decoding the reply into v.  Failed health
+// checks answer 503 with a JSON body explaining why.
+func (r *rabbitmqClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, r.base+path, nil)
+	if err != nil {
+		return fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+	req.SetBasicAuth(r.username, r.password)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 503 {
+		return fmt.Errorf("%s: response status %d", path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	return nil
+}
+
+func (r *rabbitmqClient) nodes() ([]*URL, error) {
+	nodes := make([]struct {
+		Name          string `json:"name"`
+		Running       bool   `json:"running"`
+		MemAlarm      bool   `json:"mem_alarm"`
+		DiskFreeAlarm bool   `json:"disk_free_alarm"`
+	}, 0)
+
+	if err := r.get("/api/nodes", &nodes); err != nil {
+		return nil, err
+	}
+
+	urls := make([]*URL, 0)
+
+	for _, n := range nodes {
+		state := "online"
+		switch {
+		case !n.Running:
+			state = "offline"
+		case n.MemAlarm || n.DiskFreeAlarm:
+			state = "degraded"
+		}
+
+		urls = append(urls, &URL{
+			Name:  n.Name,
+			State: state,
+			URL:   r.base + "/api/nodes/" + url.PathEscape(n.Name),
+		})
+	}
+
+	return urls, nil
+}
+
+func (r *rabbitmqClient) queue(q rabbitmqQueue) *URL {
+	vhost := q.VHost
+	if vhost == "" {
+		vhost = "/"
+	}
+
+	path := "/api/queues/" + url.PathEscape(vhost) + "/" + url.PathEscape(q.Name)
+
+	u := &URL{
+		Name:  "queue/" + q.Name,
+		State: "online",
+		URL:   r.base + path,
+	}
+
+	info := struct {
+		Messages  int `json:"messages"`
+		Consumers int `json:"consumers"`
+	}{}
+
+	if err := r.get(path, &info); err != nil {
+		u.State = "offline"
+		return u
+	}
+
+	u.Name = fmt.Sprintf("queue/%s (%d messages, %d consumers)", q.Name, info.Messages, info.Consumers)
+
+	if q.MaxMessages > 0 && info.Messages > q.MaxMessages {
+		u.State = "degraded"
+	}
+
+	if info.Consumers < q.MinConsumers {
+		u.State = "degraded"
+	}
+
+	return u
+}
+
+func (r *RabbitMQ) Check(srv Service) (bool, error) {
+	state, _, err := r.CheckTree(srv)
+	return state == "online", err
+}
+
+// Check the broker alarms and nodes through the management API, and the
+// depth and consumers of any configured queues
+func (r *RabbitMQ) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &rabbitmqOptions{Username: "guest", Password: "guest"}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return "offline", nil, fmt.Errorf("tls: %v", err)
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+
+	rc := &rabbitmqClient{
+		base:     strings.TrimRight(srv.URL, "/"),
+		username: opts.Username,
+		password: opts.Password,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: cfg},
+		},
+	}
+
+	alarms := struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}{}
+
+	if err := rc.get("/api/health/checks/alarms", &alarms); err != nil {
+		return "offline", nil, err
+	}
+
+	state := "online"
+	if alarms.Status != "ok" {
+		state = "degraded"
+	}
+
+	children, err := rc.nodes()
+	if err != nil {
+		return "degraded", nil, err
+	}
+
+	for _, q := range opts.Queues {
+		children = append(children, rc.queue(q))
+	}
+
+	for _, c := range children {
+		if c.State != "online" {
+			state = "degraded"
+		}
+	}
+
+	if alarms.Status != "ok" {
+		return state, children, fmt.Errorf("alarms: %s", alarms.Reason)
+	}
+
+	return state, children, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type testRabbit struct {
+	alarm    bool
+	running  bool
+	messages int
+}
+
+func (r *testRabbit) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if user, pass, ok := req.BasicAuth(); !ok || user != "guest" || pass != "guest" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.URL.EscapedPath() {
+	case "/api/health/checks/alarms":
+		if r.alarm {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status": "failed", "reason": "resource alarm(s) in effect"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok"}`)
+	case "/api/nodes":
+		fmt.Fprintf(w, `[{"name": "rabbit@one", "running": true, "mem_alarm": %v},
+			{"name": "rabbit@two", "running": %v}]`, r.alarm, r.running)
+	case "/api/queues/%2F/orders":
+		fmt.Fprintf(w, `{"name": "orders", "messages": %d, "consumers": 2}`, r.messages)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func testRabbitChildren(states ...string) func(*testing.T, []*Config) {
+	return func(t *testing.T, cfg []*Config) {
+		children := cfg[0].children[0]
+		if len(children) != len(states) {
+			t.Fatalf("expected %d children, got %d", len(states), len(children))
+		}
+		for i, c := range children {
+			if c.State != states[i] {
+				t.Errorf("%s state %s, expected %s", c.Name, c.State, states[i])
+			}
+		}
+	}
+}
+
+func TestCheckStatusRabbitMQ(t *testing.T) {
+	r := &testRabbit{running: true}
+	testCheckStatus(t, "rabbitmq", r.ServeHTTP, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		testRabbitChildren("online", "online")(t, cfg)
+	})
+}
+
+func TestCheckStatusRabbitMQAlarm(t *testing.T) {
+	r := &testRabbit{alarm: true, running: true}
+	testCheckStatus(t, "rabbitmq", r.ServeHTTP, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		testRabbitChildren("degraded", "online")(t, cfg)
+	})
+}
+
+func TestCheckStatusRabbitMQNodeDown(t *testing.T) {
+	r := &testRabbit{}
+	testCheckStatus(t, "rabbitmq", r.ServeHTTP, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		testRabbitChildren("online", "offline")(t, cfg)
+	})
+}
+
+func TestCheckStatusRabbitMQQueues(t *testing.T) {
+	opts := map[string]interface{}{
+		"queues": []interface{}{
+			map[string]interface{}{"name": "orders", "max_messages": 100, "min_consumers": 1},
+			map[string]interface{}{"name": "fumble"},
+		},
+	}
+
+	r := &testRabbit{running: true, messages: 10}
+	testCheckService(t, "rabbitmq", testServer(t, r), opts, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		testRabbitChildren("online", "online", "online", "offline")(t, cfg)
+	})
+
+	r = &testRabbit{running: true, messages: 1000}
+	testCheckService(t, "rabbitmq", testServer(t, r), opts, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+		testRabbitChildren("online", "online", "degraded", "offline")(t, cfg)
+	})
+}
+
+func TestCheckStatusRabbitMQBad(t *testing.T) {
+	testCheckStatus(t, "rabbitmq", testBadResponder, failCheck)
+}
+
+func TestCheckStatusRabbitMQMissing(t *testing.T) {
+	testCheckStatus(t, "rabbitmq", nil, failCheck)
+}
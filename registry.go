@@ -1,33 +1,232 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
 type Registry struct{}
 
-func (w *Registry) Check(srv Service) (bool, error) {
-	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
-	client := &http.Client{Timeout: timeout}
+type registryOptions struct {
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	Digest     string `yaml:"digest"`
+	tlsOptions `yaml:",inline"`
+}
+
+var registryManifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+var registryChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
 
-	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/", nil)
+type registryClient struct {
+	base     string
+	username string
+	password string
+	client   *http.Client
+}
+
+// Parse a WWW-Authenticate challenge into its scheme and parameters
+func registryChallenge(header string) (string, map[string]string) {
+	scheme := header
+	if i := strings.IndexByte(header, ' '); i >= 0 {
+		scheme = header[:i]
+	}
+
+	params := make(map[string]string)
+	for _, m := range registryChallengeParam.FindAllStringSubmatch(header, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+
+	return strings.ToLower(scheme), params
+}
+
+// Fetch a bearer token from the realm named in the challenge
+func (r *registryClient) token(params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("bad token realm %q", params["realm"])
+	}
+
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
 	if err != nil {
-		return false, fmt.Errorf("newrequest: %v", err)
+		return "", fmt.Errorf("newrequest: %v", err)
 	}
 
 	req.Close = true
 
-	resp, err := client.Do(req)
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("token response status %d", resp.StatusCode)
+	}
+
+	data := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", fmt.Errorf("unmarshal: %v", err)
+	}
+
+	if data.Token != "" {
+		return data.Token, nil
+	}
+	if data.AccessToken != "" {
+		return data.AccessToken, nil
+	}
+
+	return "", errors.New("empty token")
+}
+
+// GET a registry path, answering an authentication challenge once.  The
+// caller closes the response body.
+func (r *registryClient) get(path, scope string, accept []string) (*http.Response, error) {
+	var auth string
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, r.base+path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("newrequest: %v", err)
+		}
+
+		req.Close = true
+
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("client request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		scheme, params := registryChallenge(resp.Header.Get("WWW-Authenticate"))
+
+		switch scheme {
+		case "bearer":
+			if params["scope"] == "" && scope != "" {
+				params["scope"] = scope
+			}
+			token, err := r.token(params)
+			if err != nil {
+				return nil, err
+			}
+			auth = "Bearer " + token
+		case "basic":
+			if r.username == "" {
+				return nil, errors.New("basic auth required")
+			}
+			req.SetBasicAuth(r.username, r.password)
+			auth = req.Header.Get("Authorization")
+		default:
+			return nil, fmt.Errorf("unsupported auth challenge %q", scheme)
+		}
+	}
+}
+
+// Fetch the manifest of repository:tag and return its digest
+func (r *registryClient) manifest(repository, tag string) (string, error) {
+	resp, err := r.get("/v2/"+repository+"/manifests/"+tag, "repository:"+repository+":pull", registryManifestTypes)
 	if err != nil {
-		return false, fmt.Errorf("client request: %v", err)
+		return "", err
 	}
-	if resp == nil {
-		return false, fmt.Errorf("empty response")
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%s:%s: response status %d", repository, tag, resp.StatusCode)
+	}
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", fmt.Errorf("read manifest: %v", err)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Probe the v2 API, authenticating if challenged, and optionally fetch a
+// manifest and compare its digest
+func (w *Registry) Check(srv Service) (bool, error) {
+	opts := &registryOptions{Tag: "latest"}
+	if err := srv.decodeOptions(opts); err != nil {
+		return false, fmt.Errorf("options: %v", err)
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return false, fmt.Errorf("tls: %v", err)
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+
+	rc := &registryClient{
+		base:     strings.TrimRight(srv.URL, "/"),
+		username: opts.Username,
+		password: opts.Password,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: cfg},
+		},
+	}
+
+	resp, err := rc.get("/v2/", "", nil)
+	if err != nil {
+		return false, err
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, resp.Body)
@@ -35,15 +234,24 @@ func (w *Registry) Check(srv Service) (bool, error) {
 	}()
 
 	if resp.StatusCode != 200 {
-		return false, errors.New("Bad status")
+		return false, fmt.Errorf("response status %d", resp.StatusCode)
 	}
 
-	ver := resp.Header.Get("Docker-Distribution-API-Version")
+	if ver := resp.Header.Get("Docker-Distribution-API-Version"); ver != "registry/2.0" {
+		return false, fmt.Errorf("unexpected API version %q", ver)
+	}
+
+	if opts.Repository == "" {
+		return true, nil
+	}
 
-	fmt.Println(ver)
+	digest, err := rc.manifest(opts.Repository, opts.Tag)
+	if err != nil {
+		return false, err
+	}
 
-	if ver != "registry/2.0" {
-		return false, nil
+	if opts.Digest != "" && digest != opts.Digest {
+		return false, fmt.Errorf("%s:%s digest %s, expected %s", opts.Repository, opts.Tag, digest, opts.Digest)
 	}
 
 	return true, nil
@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
 
 func TestCheckStatusRegistry(t *testing.T) {
 	testCheckStatus(t, "registry", testRegistryResponder, successCheck)
@@ -13,3 +20,161 @@ func TestCheckStatusRegistryBad(t *testing.T) {
 func TestCheckStatusRegistryMissing(t *testing.T) {
 	testCheckStatus(t, "registry", nil, failCheck)
 }
+
+// Fake a private registry whose token service wants user:secret, serving
+// the manifest of library/app:v1
+func testPrivateRegistry(t *testing.T, digestHeader bool) string {
+	mux := http.NewServeMux()
+	var base string
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("service") != "test-registry" {
+			t.Errorf("service = %q", r.URL.Query().Get("service"))
+		}
+		fmt.Fprintf(w, `{"token": "tok-%s"}`, r.URL.Query().Get("scope"))
+	})
+
+	challenge := func(w http.ResponseWriter, r *http.Request, scope string) bool {
+		if r.Header.Get("Authorization") == "Bearer tok-"+scope {
+			return true
+		}
+		h := `Bearer realm="` + base + `/token",service="test-registry"`
+		if scope != "" {
+			h += `,scope="` + scope + `"`
+		}
+		w.Header().Set("WWW-Authenticate", h)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+		if r.URL.Path == "/v2/" {
+			challenge(w, r, "")
+			return
+		}
+
+		if r.URL.Path != "/v2/library/app/manifests/v1" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !challenge(w, r, "repository:library/app:pull") {
+			return
+		}
+
+		if !strings.Contains(r.Header.Get("Accept"), "manifest.v2+json") {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+
+		if digestHeader {
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		}
+		fmt.Fprint(w, `{"schemaVersion": 2}`)
+	})
+
+	base = testServer(t, mux)
+	return base
+}
+
+func TestRegistryChallenge(t *testing.T) {
+	scheme, params := registryChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull,push"`)
+
+	if scheme != "bearer" {
+		t.Errorf("scheme = %q", scheme)
+	}
+	if params["realm"] != "https://auth.docker.io/token" || params["service"] != "registry.docker.io" || params["scope"] != "repository:samalba/my-app:pull,push" {
+		t.Errorf("params = %v", params)
+	}
+}
+
+func TestRegistryToken(t *testing.T) {
+	url := testPrivateRegistry(t, true)
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username": "user",
+		"password": "secret",
+	}, successCheck)
+}
+
+func TestRegistryTokenDenied(t *testing.T) {
+	url := testPrivateRegistry(t, true)
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username": "user",
+		"password": "wrong",
+	}, failCheck)
+}
+
+func TestRegistryManifest(t *testing.T) {
+	url := testPrivateRegistry(t, true)
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username":   "user",
+		"password":   "secret",
+		"repository": "library/app",
+		"tag":        "v1",
+		"digest":     "sha256:abc",
+	}, successCheck)
+}
+
+func TestRegistryManifestMissing(t *testing.T) {
+	url := testPrivateRegistry(t, true)
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username":   "user",
+		"password":   "secret",
+		"repository": "library/app",
+		"tag":        "v2",
+	}, failCheck)
+}
+
+func TestRegistryDigestMismatch(t *testing.T) {
+	url := testPrivateRegistry(t, true)
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username":   "user",
+		"password":   "secret",
+		"repository": "library/app",
+		"tag":        "v1",
+		"digest":     "sha256:def",
+	}, failCheck)
+}
+
+func TestRegistryDigestBody(t *testing.T) {
+	url := testPrivateRegistry(t, false)
+
+	sum := sha256.Sum256([]byte(`{"schemaVersion": 2}`))
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username":   "user",
+		"password":   "secret",
+		"repository": "library/app",
+		"tag":        "v1",
+		"digest":     "sha256:" + hex.EncodeToString(sum[:]),
+	}, successCheck)
+}
+
+func TestRegistryBasic(t *testing.T) {
+	url := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		testRegistryResponder(w, r)
+	}))
+
+	testCheckService(t, "registry", url, map[string]interface{}{
+		"username": "user",
+		"password": "secret",
+	}, successCheck)
+
+	testCheckService(t, "registry", url, nil, failCheck)
+}
@@ -20,29 +20,49 @@ import (
 )
 
 type Config struct {
-	Name        string   `toml:"name"`
-	Type        string   `toml:"type"`
-	Description string   `yaml:"description"`
-	Timeout     int      `toml:"timeout"`
-	URL         []string `toml:"url"`
+	Name        string                 `toml:"name"`
+	Type        string                 `toml:"type"`
+	Description string                 `yaml:"description"`
+	Timeout     int                    `toml:"timeout"`
+	URL         []string               `toml:"url"`
+	Options     map[string]interface{} `yaml:"options"`
 	state       []string
+	children    [][]*URL
+	composite   string
 	last        time.Time
 }
 
 type Service struct {
 	URL     string
 	Timeout int
+	Options map[string]interface{}
+}
+
+// Decode the free-form options of a service into a checker specific struct
+func (s Service) decodeOptions(v interface{}) error {
+	if len(s.Options) == 0 {
+		return nil
+	}
+
+	b, err := yaml.Marshal(s.Options)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(b, v)
 }
 
 type URL struct {
-	Name  string `json:"name"`
-	State string `json:"state"`
-	URL   string `json:"url"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	URL      string `json:"url"`
+	Children []*URL `json:"children,omitempty"`
 }
 
 type Site struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	State       string `json:"state,omitempty"`
 	URLs        []*URL `json:"children"`
 }
 
@@ -55,7 +75,21 @@ type Status interface {
 	Check(Service) (bool, error)
 }
 
-var check map[string]Status
+// StateChecker is implemented by checkers that can report a service as
+// "degraded" rather than only online or offline.
+type StateChecker interface {
+	CheckState(Service) (string, error)
+}
+
+// TreeChecker is implemented by checkers that discover parts of a service,
+// such as cluster members, and report them as children of the service URL.
+type TreeChecker interface {
+	CheckTree(Service) (string, []*URL, error)
+}
+
+// Checkers by service type.  Each check gets a fresh checker, so checkers
+// may keep per-check state while the same type is checked concurrently.
+var check map[string]func() Status
 
 type server struct {
 	configfile  string
@@ -112,6 +146,7 @@ func (s *server) parseConfig() error {
 			s.cfg[i].Timeout = s.timeout
 		}
 		s.cfg[i].state = make([]string, len(s.cfg[i].URL))
+		s.cfg[i].children = make([][]*URL, len(s.cfg[i].URL))
 	}
 
 	return nil
@@ -185,14 +220,19 @@ func (s *server) processHTML() error {
 func (s *server) processSites() {
 	s.sites.Sites = make([]*Site, 0)
 	for _, c := range s.cfg {
+		if c.Type == "composite" {
+			c.composite = s.compose(c)
+		}
+
 		urls := make([]*URL, 0)
 		for i, u := range c.URL {
-			urls = append(urls, &URL{Name: u, State: c.state[i], URL: u})
+			urls = append(urls, &URL{Name: u, State: c.state[i], URL: u, Children: c.children[i]})
 		}
 
 		site := &Site{
 			Name:        c.Name,
 			Description: c.Description,
+			State:       c.composite,
 			URLs:        urls,
 		}
 
@@ -203,7 +243,7 @@ func (s *server) processSites() {
 func (s *server) checkStatus(idx, url, epoch int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	ck, ok := check[s.cfg[idx].Type]
+	newChecker, ok := check[s.cfg[idx].Type]
 	if ok == false {
 		log.Println(s.cfg[idx].Type, s.cfg[idx].URL[url], "unknown type")
 		return
@@ -212,26 +252,42 @@ func (s *server) checkStatus(idx, url, epoch int, wg *sync.WaitGroup) {
 	serv := Service{
 		Timeout: s.cfg[idx].Timeout,
 		URL:     s.cfg[idx].URL[url],
+		Options: s.cfg[idx].Options,
 	}
 
-	healthy, err := ck.Check(serv)
+	state, children, err := runCheck(newChecker(), serv)
 
 	if epoch != s.epoch {
 		log.Println("took too long - epoch has passed")
 		return
 	}
 
+	s.Lock()
+	s.cfg[idx].state[url] = state
+	s.cfg[idx].children[url] = children
+	s.Unlock()
+
+	if state != "online" {
+		log.Println(s.cfg[idx].Type, s.cfg[idx].URL[url], state, err)
+	}
+}
+
+// Run whichever form of check the checker supports
+func runCheck(ck Status, serv Service) (string, []*URL, error) {
+	switch c := ck.(type) {
+	case TreeChecker:
+		return c.CheckTree(serv)
+	case StateChecker:
+		state, err := c.CheckState(serv)
+		return state, nil, err
+	}
+
+	healthy, err := ck.Check(serv)
 	if err == nil && healthy {
-		s.Lock()
-		s.cfg[idx].state[url] = "online"
-		s.Unlock()
-		return
+		return "online", nil, nil
 	}
 
-	s.Lock()
-	s.cfg[idx].state[url] = "offline"
-	s.Unlock()
-	log.Println(s.cfg[idx].Type, s.cfg[idx].URL[url], err)
+	return "offline", nil, err
 }
 
 func (s *server) refresh(wait bool) {
@@ -245,8 +301,16 @@ func (s *server) refresh(wait bool) {
 	var wg sync.WaitGroup
 
 	for i, _ := range s.cfg {
+		if len(s.cfg[i].children) != len(s.cfg[i].URL) {
+			s.cfg[i].children = make([][]*URL, len(s.cfg[i].URL))
+		}
+		// composites are computed from the other services afterwards
+		if s.cfg[i].Type == "composite" {
+			continue
+		}
 		for u, _ := range s.cfg[i].URL {
 			s.cfg[i].state[u] = "unknown"
+			s.cfg[i].children[u] = nil
 			wg.Add(1)
 			go s.checkStatus(i, u, s.epoch, &wg)
 		}
@@ -312,15 +376,33 @@ func (s *server) statusAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 func init() {
-	check = map[string]Status{
-		"website":    new(Website),
-		"etcd":       new(Etcd),
-		"docker":     new(Docker),
-		"swarm":      new(Swarm),
-		"registry":   new(Registry),
-		"subversion": new(Subversion),
-		"telnet":     new(Telnet),
-		"consul":     new(Consul),
+	check = map[string]func() Status{
+		"website":       func() Status { return new(Website) },
+		"etcd":          func() Status { return new(Etcd) },
+		"docker":        func() Status { return new(Docker) },
+		"swarm":         func() Status { return new(Swarm) },
+		"registry":      func() Status { return new(Registry) },
+		"subversion":    func() Status { return new(Subversion) },
+		"telnet":        func() Status { return new(Telnet) },
+		"consul":        func() Status { return new(Consul) },
+		"grpc":          func() Status { return new(GRPC) },
+		"websocket":     func() Status { return new(WebSocket) },
+		"mqtt":          func() Status { return new(MQTT) },
+		"ldap":          func() Status { return new(LDAP) },
+		"ntp":           func() Status { return new(NTP) },
+		"kubernetes":    func() Status { return new(Kubernetes) },
+		"elasticsearch": func() Status { return new(Elasticsearch) },
+		"rabbitmq":      func() Status { return new(RabbitMQ) },
+		"kafka":         func() Status { return new(Kafka) },
+		"zookeeper":     func() Status { return new(ZooKeeper) },
+		"git":           func() Status { return new(Git) },
+		"exec":          func() Status { return new(Exec) },
+		"heartbeat":     func() Status { return new(Heartbeat) },
+		"file":          func() Status { return new(File) },
+		"disk":          func() Status { return new(Disk) },
+		"memory":        func() Status { return new(Memory) },
+		"load":          func() Status { return new(Load) },
+		"process":       func() Status { return new(Process) },
 	}
 }
 
@@ -343,6 +425,7 @@ func main() {
 	mux := http.NewServeMux()
 	mux.Handle("/", makeGzipHandler(s.statusHandler))
 	mux.HandleFunc("/status", s.statusAPI)
+	mux.HandleFunc("/api/v1/heartbeat/", s.heartbeatAPI)
 
 	srv := &http.Server{
 		Addr:           *bindaddr + ":" + *port,
@@ -49,6 +49,29 @@ func testCheckStatus(t *testing.T, sitetype string, handler func(http.ResponseWr
 	checker(t, cfg)
 }
 
+func testServer(t *testing.T, handler http.Handler) string {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func testCheckService(t *testing.T, sitetype, url string, options map[string]interface{}, checker func(*testing.T, []*Config)) {
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    sitetype,
+		URL:     []string{url},
+		Options: options,
+		state:   []string{"unknown"},
+		Timeout: 20,
+	}}
+
+	s := &server{cfg: cfg}
+
+	s.refresh(Wait)
+
+	checker(t, cfg)
+}
+
 func successCheck(t *testing.T, cfg []*Config) {
 	if cfg[0].state[0] != "online" {
 		t.Fatal("Status != online")
@@ -61,6 +84,12 @@ func failCheck(t *testing.T, cfg []*Config) {
 	}
 }
 
+func degradedCheck(t *testing.T, cfg []*Config) {
+	if cfg[0].state[0] != "degraded" {
+		t.Fatalf("Status = %s, expected degraded", cfg[0].state[0])
+	}
+}
+
 func TestSendStatus(t *testing.T) {
 	cfg := []*Config{{
 		Name:    "SiteCheckTest",
@@ -222,3 +251,20 @@ func TestSingleRealWorld(t *testing.T) {
 		t.Errorf("Home page didn't return %v", http.StatusOK)
 	}
 }
+
+func TestProcessSitesChildren(t *testing.T) {
+	child := &URL{Name: "member", State: "offline", URL: "http://member"}
+	s := &server{cfg: []*Config{{
+		Name:     "SiteCheckTest",
+		URL:      []string{"http://sitecheck.com"},
+		state:    []string{"degraded"},
+		children: [][]*URL{{child}},
+	}}}
+
+	s.processSites()
+
+	u := s.sites.Sites[0].URLs[0]
+	if u.State != "degraded" || len(u.Children) != 1 || u.Children[0] != child {
+		t.Fatalf("unexpected site tree %+v", u)
+	}
+}
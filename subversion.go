@@ -1,40 +1,555 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
 	"errors"
-	"os/exec"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Subversion struct{}
 
+type subversionOptions struct {
+	Username   string        `yaml:"username"`
+	Password   string        `yaml:"password"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	tlsOptions `yaml:",inline"`
+}
+
 func (s *Subversion) Check(srv Service) (bool, error) {
-	var buf bytes.Buffer
+	state, _, err := s.CheckTree(srv)
+	return state == "online", err
+}
+
+// Find the youngest revision over WebDAV or the svnserve protocol, shown
+// as a child.  With max_age set a repository whose youngest revision is
+// older than that is degraded.
+func (s *Subversion) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &subversionOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		return "offline", nil, err
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+
+	var rev int64
+	var date time.Time
+
+	switch u.Scheme {
+	case "http", "https":
+		cfg, err := opts.config()
+		if err != nil {
+			return "offline", nil, fmt.Errorf("tls: %v", err)
+		}
+		d := &svnDAV{
+			opts: opts,
+			client: &http.Client{
+				Timeout:   timeout,
+				Transport: &http.Transport{TLSClientConfig: cfg},
+			},
+		}
+		rev, date, err = d.youngest(u, opts.MaxAge > 0)
+		if err != nil {
+			return "offline", nil, err
+		}
+	case "svn":
+		rev, date, err = svnserveYoungest(u, opts, timeout, opts.MaxAge > 0)
+		if err != nil {
+			return "offline", nil, err
+		}
+	default:
+		return "offline", nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	child := &URL{
+		Name:  fmt.Sprintf("r%d", rev),
+		State: "online",
+		URL:   srv.URL,
+	}
+
+	if opts.MaxAge > 0 {
+		age := time.Since(date)
+		child.Name += fmt.Sprintf(" (%s, %s ago)", date.UTC().Format("2006-01-02 15:04"), age.Round(time.Minute))
+		if age > opts.MaxAge {
+			child.State = "degraded"
+		}
+	}
+
+	return child.State, []*URL{child}, nil
+}
+
+type svnDAV struct {
+	opts   *subversionOptions
+	client *http.Client
+}
+
+func (d *svnDAV) do(method, target, depth, body string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("newrequest: %v", err)
+	}
+
+	req.Close = true
+	req.Header.Set("Content-Type", "text/xml")
+
+	if depth != "" {
+		req.Header.Set("Depth", depth)
+	}
+
+	if d.opts.Username != "" {
+		req.SetBasicAuth(d.opts.Username, d.opts.Password)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client request: %v", err)
+	}
+
+	return resp, nil
+}
+
+// PROPFIND the DAV: properties of a single resource, returning their text
+// content; properties holding an href return the href
+func (d *svnDAV) propfind(target string, props ...string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop>`)
+	for _, p := range props {
+		body.WriteString("<D:" + p + "/>")
+	}
+	body.WriteString(`</D:prop></D:propfind>`)
+
+	resp, err := d.do("PROPFIND", target, "0", body.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != 207 {
+		return nil, fmt.Errorf("propfind %s: response status %d", target, resp.StatusCode)
+	}
+
+	wanted := make(map[string]bool)
+	for _, p := range props {
+		wanted[p] = true
+	}
+
+	values := make(map[string]string)
+	dec := xml.NewDecoder(resp.Body)
+	var current string
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("propfind %s: %v", target, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if current == "" && t.Name.Space == "DAV:" && wanted[t.Name.Local] {
+				current = t.Name.Local
+				text.Reset()
+			}
+		case xml.CharData:
+			if current != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Space == "DAV:" && t.Name.Local == current {
+				values[current] = strings.TrimSpace(text.String())
+				current = ""
+			}
+		}
+	}
 
-	cmd := exec.Command("svn", "info", srv.URL)
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
+	for _, p := range props {
+		if _, ok := values[p]; !ok {
+			return nil, fmt.Errorf("propfind %s: no %s", target, p)
+		}
+	}
+
+	return values, nil
+}
+
+// Ask OPTIONS for the HTTPv2 youngest revision, falling back to walking
+// from the version controlled configuration to its baseline
+func (d *svnDAV) youngest(u *url.URL, dated bool) (int64, time.Time, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?><D:options xmlns:D="DAV:"><D:activity-collection-set/></D:options>`
 
-	if err := cmd.Start(); err != nil {
-		return false, err
+	resp, err := d.do(http.MethodOptions, u.String(), "", body)
+	if err != nil {
+		return 0, time.Time{}, err
 	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
 
-	c := make(chan error)
+	if resp.StatusCode != 200 {
+		return 0, time.Time{}, fmt.Errorf("response status %d", resp.StatusCode)
+	}
 
-	go func() { c <- cmd.Wait() }()
+	dav := strings.Join(resp.Header.Values("DAV"), ",")
+	if !strings.Contains(dav, "version-control") {
+		return 0, time.Time{}, fmt.Errorf("not a subversion repository, DAV: %q", dav)
+	}
 
-	ticker := time.NewTicker(time.Duration(srv.Timeout) * time.Second)
+	if youngest := resp.Header.Get("SVN-Youngest-Rev"); youngest != "" {
+		rev, err := strconv.ParseInt(youngest, 10, 64)
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("SVN-Youngest-Rev: %v", err)
+		}
+
+		stub := resp.Header.Get("SVN-Rev-Root-Stub")
+		if !dated || stub == "" {
+			return rev, time.Time{}, nil
+		}
 
-	select {
-	case err := <-c:
+		root, err := u.Parse(stub + "/" + youngest)
 		if err != nil {
-			return false, err
+			return 0, time.Time{}, err
 		}
-	case <-ticker.C:
-		ticker.Stop()
-		return false, errors.New("timeout")
+
+		props, err := d.propfind(root.String(), "creationdate")
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+
+		date, err := time.Parse(time.RFC3339Nano, props["creationdate"])
+		return rev, date, err
+	}
+
+	props, err := d.propfind(u.String(), "version-controlled-configuration")
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	vcc, err := u.Parse(props["version-controlled-configuration"])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if props, err = d.propfind(vcc.String(), "checked-in"); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	baseline, err := u.Parse(props["checked-in"])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if props, err = d.propfind(baseline.String(), "version-name", "creationdate"); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	rev, err := strconv.ParseInt(props["version-name"], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("version-name: %v", err)
+	}
+
+	if !dated {
+		return rev, time.Time{}, nil
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, props["creationdate"])
+	return rev, date, err
+}
+
+// Words of the svn protocol, as opposed to strings
+type svnWord string
+
+// Reads items of the svn protocol: numbers, words, length prefixed
+// strings and parenthesised lists
+type svnReader struct {
+	r *bufio.Reader
+}
+
+func (s *svnReader) skipSpace() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+func (s *svnReader) item() (interface{}, error) {
+	c, err := s.skipSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c == '(':
+		list := make([]interface{}, 0)
+		for {
+			c, err := s.skipSpace()
+			if err != nil {
+				return nil, err
+			}
+			if c == ')' {
+				return list, nil
+			}
+			s.r.UnreadByte()
+			v, err := s.item()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c >= '0' && c <= '9':
+		n := int64(c - '0')
+		for {
+			c, err := s.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case c >= '0' && c <= '9':
+				n = n*10 + int64(c-'0')
+			case c == ':':
+				if n > 1<<20 {
+					return nil, fmt.Errorf("string of %d bytes", n)
+				}
+				b := make([]byte, n)
+				_, err := io.ReadFull(s.r, b)
+				return b, err
+			default:
+				s.r.UnreadByte()
+				return n, nil
+			}
+		}
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		word := []byte{c}
+		for {
+			c, err := s.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' || c == '\n' || c == ')' {
+				s.r.UnreadByte()
+				return svnWord(word), nil
+			}
+			word = append(word, c)
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q", c)
+}
+
+// Read a command response, returning the parameters of a success
+func (s *svnReader) response() ([]interface{}, error) {
+	v, err := s.item()
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := v.([]interface{})
+	if !ok || len(list) != 2 {
+		return nil, fmt.Errorf("malformed response %v", v)
+	}
+
+	params, _ := list[1].([]interface{})
+
+	switch list[0] {
+	case svnWord("success"):
+		return params, nil
+	case svnWord("failure"):
+		// auth failures carry a message, command errors are lists of
+		// ( apr-err message file line )
+		for _, e := range params {
+			if msg, ok := e.([]byte); ok {
+				return nil, errors.New(string(msg))
+			}
+			if e, ok := e.([]interface{}); ok && len(e) > 1 {
+				if msg, ok := e[1].([]byte); ok && len(msg) > 0 {
+					return nil, errors.New(string(msg))
+				}
+			}
+		}
+		return nil, errors.New("command failed")
+	}
+
+	return nil, fmt.Errorf("malformed response %v", v)
+}
+
+func svnString(s string) string {
+	return strconv.Itoa(len(s)) + ":" + s
+}
+
+// Answer an auth request, anonymously unless credentials are configured
+func svnserveAuth(r *svnReader, conn net.Conn, params []interface{}, opts *subversionOptions) error {
+	if len(params) < 1 {
+		return errors.New("malformed auth request")
+	}
+
+	mechs, _ := params[0].([]interface{})
+	if len(mechs) == 0 {
+		return nil
+	}
+
+	offered := make(map[svnWord]bool)
+	for _, m := range mechs {
+		if w, ok := m.(svnWord); ok {
+			offered[w] = true
+		}
+	}
+
+	switch {
+	case opts.Username != "" && offered["CRAM-MD5"]:
+		fmt.Fprint(conn, "( CRAM-MD5 ( ) ) ")
+
+		v, err := r.item()
+		if err != nil {
+			return err
+		}
+		step, ok := v.([]interface{})
+		if !ok || len(step) != 2 || step[0] != svnWord("step") {
+			return fmt.Errorf("malformed challenge %v", v)
+		}
+		args, _ := step[1].([]interface{})
+		if len(args) != 1 {
+			return fmt.Errorf("malformed challenge %v", v)
+		}
+		challenge, _ := args[0].([]byte)
+
+		mac := hmac.New(md5.New, []byte(opts.Password))
+		mac.Write(challenge)
+		fmt.Fprintf(conn, "%s ", svnString(opts.Username+" "+hex.EncodeToString(mac.Sum(nil))))
+	case offered["ANONYMOUS"]:
+		fmt.Fprint(conn, "( ANONYMOUS ( 0: ) ) ")
+	default:
+		return fmt.Errorf("no usable auth mechanism in %v", mechs)
+	}
+
+	if _, err := r.response(); err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+
+	return nil
+}
+
+// Read a command response, answering the auth request svnserve may send
+// before it
+func svnserveCommand(r *svnReader, conn net.Conn, opts *subversionOptions) ([]interface{}, error) {
+	params, err := r.response()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) == 2 {
+		if _, ok := params[0].([]interface{}); ok {
+			if err := svnserveAuth(r, conn, params, opts); err != nil {
+				return nil, err
+			}
+			return r.response()
+		}
+	}
+
+	return params, nil
+}
+
+// Complete the svnserve greeting and authentication, then ask for the
+// latest revision and optionally its date
+func svnserveYoungest(u *url.URL, opts *subversionOptions, timeout time.Duration, dated bool) (int64, time.Time, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "3690")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	r := &svnReader{r: bufio.NewReader(conn)}
+
+	greeting, err := r.response()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("greeting: %v", err)
+	}
+	if len(greeting) < 2 {
+		return 0, time.Time{}, fmt.Errorf("malformed greeting %v", greeting)
+	}
+	if max, ok := greeting[1].(int64); !ok || max < 2 {
+		return 0, time.Time{}, fmt.Errorf("unsupported protocol version %v", greeting[1])
+	}
+
+	fmt.Fprintf(conn, "( 2 ( edit-pipeline svndiff1 ) %s %s ( ) ) ", svnString(u.String()), svnString("sitecheck"))
+
+	auth, err := r.response()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("auth request: %v", err)
+	}
+	if err := svnserveAuth(r, conn, auth, opts); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if _, err := r.response(); err != nil {
+		return 0, time.Time{}, fmt.Errorf("repository: %v", err)
+	}
+
+	fmt.Fprint(conn, "( get-latest-rev ( ) ) ")
+
+	params, err := svnserveCommand(r, conn, opts)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("get-latest-rev: %v", err)
+	}
+	if len(params) != 1 {
+		return 0, time.Time{}, fmt.Errorf("get-latest-rev: malformed response %v", params)
+	}
+	rev, ok := params[0].(int64)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("get-latest-rev: malformed response %v", params)
+	}
+
+	if !dated {
+		return rev, time.Time{}, nil
+	}
+
+	fmt.Fprintf(conn, "( rev-prop ( %d %s ) ) ", rev, svnString("svn:date"))
+
+	if params, err = svnserveCommand(r, conn, opts); err != nil {
+		return 0, time.Time{}, fmt.Errorf("rev-prop: %v", err)
+	}
+
+	var value []byte
+	if len(params) == 1 {
+		if v, ok := params[0].([]interface{}); ok && len(v) == 1 {
+			value, _ = v[0].([]byte)
+		}
+	}
+	if value == nil {
+		return 0, time.Time{}, fmt.Errorf("r%d has no svn:date", rev)
 	}
 
-	return true, nil
+	date, err := time.Parse(time.RFC3339Nano, string(value))
+	return rev, date, err
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSvnDate = "2026-01-02T03:04:05.123456Z"
+
+func testSvnMultistatus(w http.ResponseWriter, href, props string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(207)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:" xmlns:lp1="DAV:"><D:response><D:href>%s</D:href>
+<D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response></D:multistatus>`, href, props)
+}
+
+// Fake mod_dav_svn, speaking HTTPv2 or only the older baseline walk
+func testSvnDAV(t *testing.T, v2 bool) string {
+	return testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodOptions && r.URL.Path == "/repo":
+			w.Header().Add("DAV", "1,2")
+			w.Header().Add("DAV", "version-control,checkout,working-resource")
+			w.Header().Add("DAV", "http://subversion.tigris.org/xmlns/dav/svn/depth")
+			if v2 {
+				w.Header().Set("SVN-Youngest-Rev", "42")
+				w.Header().Set("SVN-Rev-Root-Stub", "/repo/!svn/rvr")
+			}
+		case r.Method == "PROPFIND" && r.Header.Get("Depth") != "0":
+			t.Errorf("Depth = %q", r.Header.Get("Depth"))
+		case r.Method == "PROPFIND" && v2 && r.URL.Path == "/repo/!svn/rvr/42":
+			testSvnMultistatus(w, r.URL.Path, "<lp1:creationdate>"+testSvnDate+"</lp1:creationdate>")
+		case r.Method == "PROPFIND" && !v2 && r.URL.Path == "/repo":
+			testSvnMultistatus(w, r.URL.Path, "<lp1:version-controlled-configuration><D:href>/repo/!svn/vcc/default</D:href></lp1:version-controlled-configuration>")
+		case r.Method == "PROPFIND" && !v2 && r.URL.Path == "/repo/!svn/vcc/default":
+			testSvnMultistatus(w, r.URL.Path, "<D:checked-in><D:href>/repo/!svn/bln/42</D:href></D:checked-in>")
+		case r.Method == "PROPFIND" && !v2 && r.URL.Path == "/repo/!svn/bln/42":
+			testSvnMultistatus(w, r.URL.Path, "<D:version-name>42</D:version-name><D:creationdate>"+testSvnDate+"</D:creationdate>")
+		default:
+			http.NotFound(w, r)
+		}
+	})) + "/repo"
+}
+
+var testSvnAuth = map[string]interface{}{"username": "user", "password": "secret"}
+
+func testSvnChild(name string) func(*testing.T, []*Config) {
+	return func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 1 || children[0].Name != name {
+			t.Fatalf("children = %v, expected %s", children, name)
+		}
+	}
+}
+
+func TestSubversionMissing(t *testing.T) {
+	testCheckStatus(t, "subversion", nil, failCheck)
+}
+
+func TestSubversionNotDAV(t *testing.T) {
+	testCheckStatus(t, "subversion", testSimpleResponder, failCheck)
+}
+
+func TestSubversionHTTPv2(t *testing.T) {
+	testCheckService(t, "subversion", testSvnDAV(t, true), testSvnAuth, testSvnChild("r42"))
+}
+
+func TestSubversionHTTPv1(t *testing.T) {
+	testCheckService(t, "subversion", testSvnDAV(t, false), testSvnAuth, testSvnChild("r42"))
+}
+
+func TestSubversionUnauthorized(t *testing.T) {
+	testCheckService(t, "subversion", testSvnDAV(t, true), nil, failCheck)
+}
+
+func TestSubversionMaxAge(t *testing.T) {
+	for _, v2 := range []bool{true, false} {
+		options := map[string]interface{}{"username": "user", "password": "secret", "max_age": "1h"}
+
+		testCheckService(t, "subversion", testSvnDAV(t, v2), options, func(t *testing.T, cfg []*Config) {
+			degradedCheck(t, cfg)
+
+			children := cfg[0].children[0]
+			if !strings.HasPrefix(children[0].Name, "r42 (2026-01-02 03:04, ") {
+				t.Errorf("child = %s", children[0].Name)
+			}
+		})
+	}
+}
+
+// Fake svnserve, requiring CRAM-MD5 when a password is set.  Requests are
+// answered by their command word.
+func testSvnserve(t *testing.T, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go testSvnserveConn(t, conn, password)
+		}
+	}()
+
+	return "svn://" + ln.Addr().String() + "/repo"
+}
+
+func testSvnserveConn(t *testing.T, conn net.Conn, password string) {
+	defer conn.Close()
+
+	r := &svnReader{r: bufio.NewReader(conn)}
+
+	fmt.Fprint(conn, "( success ( 2 2 ( ) ( edit-pipeline svndiff1 depth log-revprops ) ) ) ")
+
+	greeting, err := r.item()
+	if err != nil {
+		return
+	}
+	if g, ok := greeting.([]interface{}); !ok || len(g) < 3 || g[0] != int64(2) {
+		t.Errorf("client greeting = %v", greeting)
+		return
+	}
+
+	auth := func() bool {
+		if password == "" {
+			fmt.Fprint(conn, "( success ( ( ANONYMOUS ) 4:test ) ) ")
+			mech, err := r.item()
+			if m, ok := mech.([]interface{}); err != nil || !ok || m[0] != svnWord("ANONYMOUS") {
+				t.Errorf("mech = %v", mech)
+				return false
+			}
+			fmt.Fprint(conn, "( success ( ) ) ")
+			return true
+		}
+
+		fmt.Fprint(conn, "( success ( ( CRAM-MD5 ) 4:test ) ) ")
+		if _, err := r.item(); err != nil {
+			return false
+		}
+
+		challenge := "<1234.5678@test>"
+		fmt.Fprintf(conn, "( step ( %d:%s ) ) ", len(challenge), challenge)
+
+		v, err := r.item()
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write([]byte(challenge))
+		if resp, _ := v.([]byte); string(resp) != "user "+hex.EncodeToString(mac.Sum(nil)) {
+			fmt.Fprint(conn, "( failure ( 21:Authentication failed ) ) ")
+			return false
+		}
+		fmt.Fprint(conn, "( success ( ) ) ")
+		return true
+	}
+
+	if !auth() {
+		return
+	}
+
+	fmt.Fprint(conn, "( success ( 36:c8c4a5a0-0000-0000-0000-000000000000 4:repo ( mergeinfo ) ) ) ")
+
+	for {
+		v, err := r.item()
+		if err != nil {
+			return
+		}
+		cmd, _ := v.([]interface{})
+		if len(cmd) != 2 {
+			t.Errorf("command = %v", v)
+			return
+		}
+
+		fmt.Fprint(conn, "( success ( ( ) 0: ) ) ")
+
+		switch cmd[0] {
+		case svnWord("get-latest-rev"):
+			fmt.Fprint(conn, "( success ( 42 ) ) ")
+		case svnWord("rev-prop"):
+			args, _ := cmd[1].([]interface{})
+			if len(args) != 2 || args[0] != int64(42) || string(args[1].([]byte)) != "svn:date" {
+				t.Errorf("rev-prop %v", args)
+			}
+			fmt.Fprintf(conn, "( success ( ( %d:%s ) ) ) ", len(testSvnDate), testSvnDate)
+		default:
+			fmt.Fprint(conn, "( failure ( ( 210001 11:Unsupported 0: 0 ) ) ) ")
+		}
+	}
+}
+
+func TestSvnReader(t *testing.T) {
+	r := &svnReader{r: bufio.NewReader(strings.NewReader("( success ( 12 word 5:a b c ( ) ) ) "))}
+
+	v, err := r.item()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[success [12 word [97 32 98 32 99] []]]"
+	if got := fmt.Sprint(v); got != expected {
+		t.Errorf("item = %s, expected %s", got, expected)
+	}
+}
+
+func TestSubversionSvnserve(t *testing.T) {
+	testCheckService(t, "subversion", testSvnserve(t, ""), nil, testSvnChild("r42"))
+}
+
+func TestSubversionSvnserveAuth(t *testing.T) {
+	url := testSvnserve(t, "secret")
+
+	testCheckService(t, "subversion", url, testSvnAuth, testSvnChild("r42"))
+
+	testCheckService(t, "subversion", url, map[string]interface{}{"username": "user", "password": "wrong"}, failCheck)
+}
+
+func TestSubversionSvnserveMaxAge(t *testing.T) {
+	url := testSvnserve(t, "")
+
+	testCheckService(t, "subversion", url, map[string]interface{}{"max_age": "1h"}, degradedCheck)
+
+	age := time.Since(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) + 24*time.Hour
+	testCheckService(t, "subversion", url, map[string]interface{}{"max_age": age.String()}, successCheck)
+	testCheckService(t, "subversion", url, map[string]interface{}{"max_age": "a while"}, failCheck)
+}
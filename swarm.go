@@ -1,137 +1,163 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
 )
 
-type Swarm struct {
-	transport *http.Transport
-	once      sync.Once
+type Swarm struct{}
+
+type swarmNode struct {
+	ID          string `json:"ID"`
+	Description struct {
+		Hostname string `json:"Hostname"`
+	} `json:"Description"`
+	Spec struct {
+		Role         string `json:"Role"`
+		Availability string `json:"Availability"`
+	} `json:"Spec"`
+	Status struct {
+		State string `json:"State"`
+	} `json:"Status"`
+	ManagerStatus *struct {
+		Leader       bool   `json:"Leader"`
+		Reachability string `json:"Reachability"`
+	} `json:"ManagerStatus"`
 }
 
-type swarminfo struct {
-	BridgeNfIp6tables  bool        `json:"BridgeNfIp6tables"`
-	BridgeNfIptables   bool        `json:"BridgeNfIptables"`
-	Containers         float64     `json:"Containers"`
-	Debug              bool        `json:"Debug"`
-	DockerRootDir      string      `json:"DockerRootDir"`
-	Driver             string      `json:"Driver"`
-	DriverStatus       [][]string  `json:"DriverStatus"`
-	ExecutionDriver    string      `json:"ExecutionDriver"`
-	HttpProxy          string      `json:"HttpProxy"`
-	HttpsProxy         string      `json:"HttpsProxy"`
-	ID                 string      `json:"ID"`
-	IPv4Forwarding     bool        `json:"IPv4Forwarding"`
-	Images             float64     `json:"Images"`
-	IndexServerAddress string      `json:"IndexServerAddress"`
-	InitPath           string      `json:"InitPath"`
-	InitSha1           string      `json:"InitSha1"`
-	KernelVersion      string      `json:"KernelVersion"`
-	Labels             interface{} `json:"Labels"`
-	MemTotal           float64     `json:"MemTotal"`
-	MemoryLimit        bool        `json:"MemoryLimit"`
-	NCPU               float64     `json:"NCPU"`
-	NEventsListener    float64     `json:"NEventsListener"`
-	NFd                float64     `json:"NFd"`
-	NGoroutines        float64     `json:"NGoroutines"`
-	Name               string      `json:"Name"`
-	NoProxy            string      `json:"NoProxy"`
-	OperatingSystem    string      `json:"OperatingSystem"`
-	SwapLimit          bool        `json:"SwapLimit"`
-	SystemTime         string      `json:"SystemTime"`
+type swarmService struct {
+	ID   string `json:"ID"`
+	Spec struct {
+		Name string `json:"Name"`
+		Mode struct {
+			Replicated *struct {
+				Replicas int `json:"Replicas"`
+			} `json:"Replicated"`
+			Global *struct{} `json:"Global"`
+		} `json:"Mode"`
+	} `json:"Spec"`
 }
 
-func (s *Swarm) setupTLS() {
-	home := os.Getenv("HOME")
-	if home == "" {
-		log.Println("HOME environment not configured")
-		return
-	}
+type swarmTask struct {
+	ServiceID string `json:"ServiceID"`
+	Status    struct {
+		State string `json:"State"`
+	} `json:"Status"`
+}
 
-	certFile := home + "/.docker/cert.pem"
-	keyFile := home + "/.docker/key.pem"
-	caFile := home + "/.docker/ca.pem"
+func (s *Swarm) Check(srv Service) (bool, error) {
+	state, _, err := s.CheckTree(srv)
+	return state == "online", err
+}
 
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		log.Println(err)
-		return
+// Check manager quorum, report nodes that aren't ready and active, and
+// show each service as a child comparing running tasks to desired replicas
+func (s *Swarm) CheckTree(srv Service) (string, []*URL, error) {
+	base := srv.URL
+	var transport *http.Transport
+
+	if strings.HasPrefix(srv.URL, "unix://") {
+		base = "http://docker"
+		transport = dockerUnixTransport(strings.TrimPrefix(srv.URL, "unix://"))
+	} else {
+		transport = dockerTLSTransport()
 	}
 
-	caCert, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	client := &http.Client{Timeout: timeout}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
+	if transport != nil {
+		client.Transport = transport
+	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
+	nodes := make([]swarmNode, 0)
+	if err := dockerGet(client, base+"/nodes", &nodes); err != nil {
+		return "offline", nil, err
 	}
-	tlsConfig.BuildNameToCertificate()
-	s.transport = &http.Transport{TLSClientConfig: tlsConfig}
-}
 
-func (s *Swarm) Check(srv Service) (bool, error) {
-	var err error
-	var resp *http.Response
+	children := make([]*URL, 0)
 
-	s.once.Do(s.setupTLS)
+	managers, reachable := 0, 0
 
-	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
-	client := &http.Client{Timeout: timeout}
+	for _, n := range nodes {
+		if n.ManagerStatus != nil {
+			managers++
+			if n.ManagerStatus.Reachability == "reachable" {
+				reachable++
+			}
+		}
 
-	req, err := http.NewRequest(http.MethodGet, srv.URL+"/info", nil)
-	if err != nil {
-		return false, fmt.Errorf("newrequest: %v", err)
-	}
+		state := "online"
+		switch {
+		case n.Status.State != "ready":
+			state = "offline"
+		case n.Spec.Availability != "active":
+			state = "degraded"
+		default:
+			continue
+		}
 
-	req.Close = true
+		children = append(children, &URL{
+			Name:  fmt.Sprintf("node/%s (%s, %s)", n.Description.Hostname, n.Status.State, n.Spec.Availability),
+			State: state,
+			URL:   base + "/nodes/" + n.ID,
+		})
+	}
 
-	if s.transport != nil {
-		client.Transport = s.transport
+	if reachable <= managers/2 {
+		return "offline", children, fmt.Errorf("%d of %d managers reachable, no quorum", reachable, managers)
 	}
-	resp, err = client.Do(req)
-	if err != nil {
-		return false, fmt.Errorf("client request: %v", err)
+
+	services := make([]swarmService, 0)
+	if err := dockerGet(client, base+"/services", &services); err != nil {
+		return "degraded", children, err
 	}
-	if resp == nil {
-		return false, fmt.Errorf("empty response")
+
+	tasks := make([]swarmTask, 0)
+	filters := url.QueryEscape(`{"desired-state":["running"]}`)
+	if err := dockerGet(client, base+"/tasks?filters="+filters, &tasks); err != nil {
+		return "degraded", children, err
 	}
-	defer func() {
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-	}()
 
-	if resp.StatusCode != 200 {
-		return false, fmt.Errorf("response status %d", resp.StatusCode)
+	// global services have a task for each node their constraints allow
+	running, scheduled := make(map[string]int), make(map[string]int)
+	for _, t := range tasks {
+		scheduled[t.ServiceID]++
+		if t.Status.State == "running" {
+			running[t.ServiceID]++
+		}
 	}
 
-	info := &swarminfo{}
+	for _, svc := range services {
+		desired := scheduled[svc.ID]
+		if svc.Spec.Mode.Replicated != nil {
+			desired = svc.Spec.Mode.Replicated.Replicas
+		}
 
-	err = json.NewDecoder(resp.Body).Decode(info)
-	if err != nil {
-		return false, fmt.Errorf("unmarshal: %v", err)
+		state := "online"
+		switch {
+		case running[svc.ID] >= desired:
+		case running[svc.ID] == 0:
+			state = "offline"
+		default:
+			state = "degraded"
+		}
+
+		children = append(children, &URL{
+			Name:  fmt.Sprintf("%s (%d/%d)", svc.Spec.Name, running[svc.ID], desired),
+			State: state,
+			URL:   base + "/services/" + svc.ID,
+		})
 	}
-	/*
-		for _, x := range info.DriverStatus {
-			for _, y := range x {
-				fmt.Println(y)
-			}
+
+	for _, c := range children {
+		if c.State != "online" {
+			return "degraded", children, nil
 		}
-	*/
-	return true, nil
+	}
+
+	return "online", children, nil
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+type testSwarmNode struct {
+	host, role, state, availability, reachability string
+}
+
+// Fake a swarm manager with the given nodes and a replicated "web" service
+// and global "agent" service scheduled on agentTasks nodes
+func testSwarm(t *testing.T, nodes []testSwarmNode, webReplicas, webRunning, agentTasks, agentRunning int) string {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		list := make([]map[string]interface{}, 0)
+		for _, n := range nodes {
+			node := map[string]interface{}{
+				"ID":          n.host,
+				"Description": map[string]interface{}{"Hostname": n.host},
+				"Spec":        map[string]interface{}{"Role": n.role, "Availability": n.availability},
+				"Status":      map[string]interface{}{"State": n.state},
+			}
+			if n.role == "manager" {
+				node["ManagerStatus"] = map[string]interface{}{"Reachability": n.reachability}
+			}
+			list = append(list, node)
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+
+	mux.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"ID": "s1", "Spec": {"Name": "web", "Mode": {"Replicated": {"Replicas": ` + strconv.Itoa(webReplicas) + `}}}},
+			{"ID": "s2", "Spec": {"Name": "agent", "Mode": {"Global": {}}}}
+		]`))
+	})
+
+	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filters") != `{"desired-state":["running"]}` {
+			t.Errorf("filters = %q", r.URL.Query().Get("filters"))
+		}
+		tasks := make([]map[string]interface{}, 0)
+		add := func(id, state string, n int) {
+			for i := 0; i < n; i++ {
+				tasks = append(tasks, map[string]interface{}{
+					"ServiceID": id,
+					"Status":    map[string]interface{}{"State": state},
+				})
+			}
+		}
+		add("s1", "running", webRunning)
+		add("s2", "running", agentRunning)
+		add("s2", "pending", agentTasks-agentRunning)
+		tasks = append(tasks, map[string]interface{}{
+			"ServiceID": "s1",
+			"Status":    map[string]interface{}{"State": "preparing"},
+		})
+		json.NewEncoder(w).Encode(tasks)
+	})
+
+	return testServer(t, mux)
+}
+
+var testSwarmHealthy = []testSwarmNode{
+	{"m1", "manager", "ready", "active", "reachable"},
+	{"m2", "manager", "ready", "active", "reachable"},
+	{"m3", "manager", "ready", "active", "reachable"},
+	{"w1", "worker", "ready", "active", ""},
+}
+
+func TestSwarmMissing(t *testing.T) {
+	testCheckStatus(t, "swarm", nil, failCheck)
+}
+
+func TestSwarmBad(t *testing.T) {
+	testCheckStatus(t, "swarm", testBadResponder, failCheck)
+}
+
+func TestSwarmOnline(t *testing.T) {
+	url := testSwarm(t, testSwarmHealthy, 3, 3, 4, 4)
+
+	testCheckService(t, "swarm", url, nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 2 {
+			t.Fatalf("children = %d, expected 2", len(children))
+		}
+		if children[0].Name != "web (3/3)" || children[1].Name != "agent (4/4)" {
+			t.Errorf("children = %s, %s", children[0].Name, children[1].Name)
+		}
+	})
+}
+
+func TestSwarmServices(t *testing.T) {
+	url := testSwarm(t, testSwarmHealthy, 3, 1, 4, 0)
+
+	testCheckService(t, "swarm", url, nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if children[0].State != "degraded" || children[1].State != "offline" {
+			t.Errorf("states = %s, %s", children[0].State, children[1].State)
+		}
+	})
+}
+
+// A global service constrained to the managers only runs on three nodes
+func TestSwarmGlobalConstrained(t *testing.T) {
+	url := testSwarm(t, testSwarmHealthy, 3, 3, 3, 3)
+
+	testCheckService(t, "swarm", url, nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+
+		if name := cfg[0].children[0][1].Name; name != "agent (3/3)" {
+			t.Errorf("child = %q", name)
+		}
+	})
+
+	url = testSwarm(t, testSwarmHealthy, 3, 3, 3, 2)
+
+	testCheckService(t, "swarm", url, nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		if name := cfg[0].children[0][1].Name; name != "agent (2/3)" {
+			t.Errorf("child = %q", name)
+		}
+	})
+}
+
+func TestSwarmNodes(t *testing.T) {
+	nodes := []testSwarmNode{
+		{"m1", "manager", "ready", "active", "reachable"},
+		{"m2", "manager", "ready", "drain", "reachable"},
+		{"m3", "manager", "down", "active", "unreachable"},
+		{"w1", "worker", "ready", "active", ""},
+	}
+	url := testSwarm(t, nodes, 2, 2, 2, 2)
+
+	testCheckService(t, "swarm", url, nil, func(t *testing.T, cfg []*Config) {
+		degradedCheck(t, cfg)
+
+		children := cfg[0].children[0]
+		if len(children) != 4 {
+			t.Fatalf("children = %d, expected 4", len(children))
+		}
+		if children[0].Name != "node/m2 (ready, drain)" || children[0].State != "degraded" {
+			t.Errorf("child = %s %s", children[0].Name, children[0].State)
+		}
+		if children[1].Name != "node/m3 (down, active)" || children[1].State != "offline" {
+			t.Errorf("child = %s %s", children[1].Name, children[1].State)
+		}
+	})
+}
+
+func TestSwarmQuorum(t *testing.T) {
+	nodes := []testSwarmNode{
+		{"m1", "manager", "ready", "active", "reachable"},
+		{"m2", "manager", "down", "active", "unreachable"},
+		{"m3", "manager", "down", "active", "unreachable"},
+	}
+	url := testSwarm(t, nodes, 1, 1, 1, 1)
+
+	testCheckService(t, "swarm", url, nil, failCheck)
+}
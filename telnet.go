@@ -1,16 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"time"
 )
 
+// Telnet holds the deadline of a single check, the check map creates one
+// for each
 type Telnet struct {
 	deadline time.Time
 }
 
+type telnetStep struct {
+	Expect string `yaml:"expect"`
+	Send   string `yaml:"send"`
+}
+
+type telnetOptions struct {
+	Script []telnetStep `yaml:"script"`
+}
+
 const (
 	debug = false
 
@@ -184,33 +197,94 @@ func (t *Telnet) sendAYT(c net.Conn) error {
 	return nil
 }
 
+// Return the length of the command at the start of buf, or 0 when buf
+// holds only part of one
 func (t *Telnet) decodeCommand(buf []byte) int {
-	if buf[0] != CommandIAC {
+	if len(buf) < 2 || buf[0] != CommandIAC {
 		return 0
 	}
 
 	t.debugf("%2.2x %s\n", buf[0], t.CommandText(int(buf[0])))
 	t.debugf("%2.2x %s\n", buf[1], t.CommandText(int(buf[1])))
 
-	i := 1
+	switch buf[1] {
+	case CommandDO, CommandDONT, CommandWILL, CommandWONT:
+		if len(buf) < 3 {
+			return 0
+		}
+		t.debugf("%2.2x %s\n", buf[2], t.OptionText(int(buf[2])))
+		return 3
+	case CommandSB:
+		end := bytes.Index(buf, []byte{CommandIAC, CommandSE})
+		if end < 0 {
+			return 0
+		}
+		return end + 2
+	}
 
-	switch buf[i] {
+	return 2
+}
+
+// Refuse every option the server asks us to enable or offers to enable.
+// Refusals need no reply, so negotiation can't loop.
+func (t *Telnet) negotiate(c net.Conn, cmd []byte) error {
+	var reply []byte
+
+	switch cmd[1] {
 	case CommandDO:
-		fallthrough
-	case CommandDONT:
-		fallthrough
+		reply = []byte{CommandIAC, CommandWONT, cmd[2]}
 	case CommandWILL:
-		fallthrough
-	case CommandWONT:
-		i++
-		t.debugf("%2.2x %s\n", buf[i], t.OptionText(int(buf[i])))
+		reply = []byte{CommandIAC, CommandDONT, cmd[2]}
+	default:
+		return nil
+	}
+
+	t.debugf("\t\t\t\t%s %s\n", t.CommandText(int(reply[1])), t.OptionText(int(reply[2])))
+
+	if err := c.SetWriteDeadline(t.deadline); err != nil {
+		return err
 	}
 
-	return i
+	_, err := c.Write(reply)
+	return err
+}
+
+// Strip commands from received bytes, answering negotiations.  An
+// incomplete command at the end is returned to be completed by the next
+// read.
+func (t *Telnet) filter(c net.Conn, buf []byte) ([]byte, []byte, error) {
+	text := make([]byte, 0, len(buf))
+
+	for i := 0; i < len(buf); {
+		if buf[i] != CommandIAC {
+			t.debugf("%2.2x %c\n", buf[i], buf[i])
+			text = append(text, buf[i])
+			i++
+			continue
+		}
+
+		n := t.decodeCommand(buf[i:])
+		if n == 0 {
+			return text, buf[i:], nil
+		}
+
+		if buf[i+1] == CommandIAC {
+			text = append(text, CommandIAC)
+		}
+
+		if err := t.negotiate(c, buf[i:i+n]); err != nil {
+			return text, nil, err
+		}
+
+		i += n
+	}
+
+	return text, nil, nil
 }
 
 func (t *Telnet) recv(c net.Conn) (int, error) {
 	buf := make([]byte, 512)
+	var pending []byte
 
 	count := 0
 	for {
@@ -227,13 +301,8 @@ func (t *Telnet) recv(c net.Conn) (int, error) {
 			return count, fmt.Errorf("read error: %v\n", err)
 		}
 
-		for i := 0; i < n; i++ {
-			if cnt := t.decodeCommand(buf[i:n]); cnt > 0 {
-				i += cnt
-				continue
-			}
-
-			t.debugf("%2.2x %c\n", buf[i], buf[i])
+		if _, pending, err = t.filter(c, append(pending, buf[:n]...)); err != nil {
+			return count, err
 		}
 
 		count += n
@@ -244,13 +313,82 @@ func (t *Telnet) recv(c net.Conn) (int, error) {
 	}
 }
 
+// Run an expect/send dialogue.  Each step waits for its expect pattern,
+// if any, in the text received since the previous match, then sends its
+// line terminated by CR LF.
+func (t *Telnet) script(c net.Conn, steps []telnetStep) error {
+	buf := make([]byte, 512)
+	var text, pending []byte
+
+	for i, step := range steps {
+		if step.Expect != "" {
+			re, err := regexp.Compile(step.Expect)
+			if err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+
+			for {
+				if loc := re.FindIndex(text); loc != nil {
+					text = text[loc[1]:]
+					break
+				}
+
+				if err := c.SetReadDeadline(t.deadline); err != nil {
+					return err
+				}
+
+				n, err := c.Read(buf)
+				if err != nil {
+					tail := text
+					if len(tail) > 80 {
+						tail = tail[len(tail)-80:]
+					}
+					return fmt.Errorf("step %d: expected %q, got %q: %v", i+1, step.Expect, tail, err)
+				}
+
+				var got []byte
+				if got, pending, err = t.filter(c, append(pending, buf[:n]...)); err != nil {
+					return err
+				}
+				text = append(text, got...)
+			}
+		}
+
+		if step.Send != "" {
+			if err := c.SetWriteDeadline(t.deadline); err != nil {
+				return err
+			}
+			if _, err := c.Write([]byte(step.Send + "\r\n")); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Run the configured script, or without one check the server answers an
+// Are You There
 func (t *Telnet) Check(srv Service) (bool, error) {
+	opts := &telnetOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return false, fmt.Errorf("options: %v", err)
+	}
+
 	t.deadline = time.Now().Add(time.Duration(time.Duration(srv.Timeout) * time.Second))
 
 	c, err := net.DialTimeout("tcp", srv.URL, t.deadline.Sub(time.Now()))
 	if err != nil {
 		return false, err
 	}
+	defer c.Close()
+
+	if len(opts.Script) > 0 {
+		if err := t.script(c, opts.Script); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
 
 	_, err = t.recv(c)
 	if err != nil {
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Fake telnet server, asking to negotiate options before a login dialogue.
+// An Are You There is answered instead when the client sends one.
+func testTelnet(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go testTelnetConn(t, conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func testTelnetConn(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	// split the negotiation and a window size subnegotiation across writes
+	conn.Write([]byte{CommandIAC, CommandDO, OptionECHO, CommandIAC})
+	conn.Write([]byte{CommandWILL, OptionSGA, CommandIAC, CommandSB, OptionNAWS, 0, 80})
+	conn.Write([]byte{0, 24, CommandIAC, CommandSE})
+
+	replies := make([]byte, 6)
+	if _, err := io.ReadFull(r, replies); err != nil {
+		return
+	}
+	expected := []byte{CommandIAC, CommandWONT, OptionECHO, CommandIAC, CommandDONT, OptionSGA}
+	if !bytes.Equal(replies, expected) {
+		t.Errorf("negotiation replies = %v, expected %v", replies, expected)
+		return
+	}
+
+	// read a line, answering any Are You There on the way
+	prompt := func(p string) string {
+		conn.Write([]byte(p))
+		var line []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return ""
+			}
+			switch {
+			case c == CommandIAC:
+				if cmd, _ := r.ReadByte(); cmd == CommandAYT {
+					conn.Write([]byte("[yes]\r\n"))
+				}
+			case c == '\n':
+				return strings.TrimSuffix(string(line), "\r")
+			default:
+				line = append(line, c)
+			}
+		}
+	}
+
+	user := prompt("\r\nrouter1 login: ")
+	pass := prompt("Password: ")
+	if user != "admin" || pass != "secret" {
+		conn.Write([]byte("Login incorrect\r\n"))
+		return
+	}
+
+	// an escaped 255 byte in the banner
+	conn.Write([]byte{'b', 'a', 'n', 'n', 'e', 'r', CommandIAC, CommandIAC, '\r', '\n'})
+
+	for {
+		cmd := prompt("router1> ")
+		switch cmd {
+		case "show version":
+			conn.Write([]byte("Version 12.4(3)\r\n"))
+		case "exit", "":
+			return
+		}
+	}
+}
+
+var testTelnetScript = []interface{}{
+	map[string]interface{}{"expect": "login: $", "send": "admin"},
+	map[string]interface{}{"expect": "Password: $", "send": "secret"},
+	map[string]interface{}{"expect": "> $", "send": "show version"},
+	map[string]interface{}{"expect": `Version 12\.\d`},
+	map[string]interface{}{"expect": "> $", "send": "exit"},
+}
+
+func TestTelnetMissing(t *testing.T) {
+	testCheckService(t, "telnet", "127.0.0.1:55555", nil, failCheck)
+}
+
+func TestTelnetAYT(t *testing.T) {
+	testCheckService(t, "telnet", testTelnet(t), nil, successCheck)
+}
+
+func TestTelnetScript(t *testing.T) {
+	options := map[string]interface{}{"script": testTelnetScript}
+
+	testCheckService(t, "telnet", testTelnet(t), options, successCheck)
+}
+
+func TestTelnetScriptMismatch(t *testing.T) {
+	script := append([]interface{}{}, testTelnetScript[:3]...)
+	script = append(script,
+		map[string]interface{}{"expect": "> $", "send": "exit"},
+		map[string]interface{}{"expect": "Version 15"})
+
+	options := map[string]interface{}{"script": script}
+
+	testCheckService(t, "telnet", testTelnet(t), options, failCheck)
+}
+
+func TestTelnetScriptLogin(t *testing.T) {
+	script := []interface{}{
+		map[string]interface{}{"expect": "login: $", "send": "admin"},
+		map[string]interface{}{"expect": "Password: $", "send": "wrong"},
+		map[string]interface{}{"expect": "> $"},
+	}
+
+	options := map[string]interface{}{"script": script}
+
+	testCheckService(t, "telnet", testTelnet(t), options, failCheck)
+}
+
+func TestTelnetScriptBadPattern(t *testing.T) {
+	options := map[string]interface{}{"script": []interface{}{map[string]interface{}{"expect": "("}}}
+
+	testCheckService(t, "telnet", testTelnet(t), options, failCheck)
+}
+
+func TestTelnetFilter(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	tn := &Telnet{}
+
+	text, pending, err := tn.filter(c1, []byte{'a', CommandIAC, CommandNOP, 'b', CommandIAC, CommandIAC, 'c', CommandIAC, CommandWONT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "ab\xffc" {
+		t.Errorf("text = %q", text)
+	}
+	if !bytes.Equal(pending, []byte{CommandIAC, CommandWONT}) {
+		t.Errorf("pending = %v", pending)
+	}
+}
+
+// Many telnet targets checked at once must each keep their own deadline
+func TestTelnetConcurrent(t *testing.T) {
+	cfg := make([]*Config, 0)
+
+	for i := 0; i < 16; i++ {
+		c := &Config{
+			Name:    fmt.Sprintf("SiteCheckTest%d", i),
+			Type:    "telnet",
+			URL:     []string{testTelnet(t), testTelnet(t)},
+			state:   []string{"unknown", "unknown"},
+			Timeout: 5 + i,
+		}
+		if i%2 == 0 {
+			c.Options = map[string]interface{}{"script": testTelnetScript}
+		}
+		cfg = append(cfg, c)
+	}
+
+	s := &server{cfg: cfg}
+
+	s.refresh(Wait)
+
+	for _, c := range cfg {
+		for i, state := range c.state {
+			if state != "online" {
+				t.Errorf("%s %s = %s, expected online", c.Name, c.URL[i], state)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type WebSocket struct{}
+
+type websocketOptions struct {
+	Origin     string            `yaml:"origin"`
+	Header     map[string]string `yaml:"header"`
+	Send       string            `yaml:"send"`
+	Expect     string            `yaml:"expect"`
+	tlsOptions `yaml:",inline"`
+}
+
+// Upgrade to a websocket, optionally exchange a message, and close
+func (w *WebSocket) Check(srv Service) (bool, error) {
+	opts := &websocketOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return false, fmt.Errorf("options: %v", err)
+	}
+
+	var expect *regexp.Regexp
+	if opts.Expect != "" {
+		re, err := regexp.Compile(opts.Expect)
+		if err != nil {
+			return false, fmt.Errorf("expect: %v", err)
+		}
+		expect = re
+	}
+
+	origin := opts.Origin
+	if origin == "" {
+		origin = strings.Replace(srv.URL, "ws", "http", 1)
+	}
+
+	config, err := websocket.NewConfig(srv.URL, origin)
+	if err != nil {
+		return false, fmt.Errorf("config: %v", err)
+	}
+
+	for k, v := range opts.Header {
+		config.Header.Set(k, v)
+	}
+
+	if config.Location.Scheme == "wss" {
+		config.TlsConfig, err = opts.config()
+		if err != nil {
+			return false, fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	timeout := time.Duration(time.Duration(srv.Timeout) * time.Second)
+	config.Dialer = &net.Dialer{Timeout: timeout}
+
+	// the dialer only limits connecting, not waiting for the upgrade
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("handshake: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+
+	if opts.Send != "" {
+		if err := websocket.Message.Send(ws, opts.Send); err != nil {
+			return false, fmt.Errorf("send: %v", err)
+		}
+	}
+
+	if expect == nil {
+		return true, nil
+	}
+
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return false, fmt.Errorf("receive: %v", err)
+		}
+
+		if expect.MatchString(msg) {
+			return true, nil
+		}
+	}
+}
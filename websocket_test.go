@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func testWebSocketServer(t *testing.T, handler websocket.Handler) string {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return strings.Replace(ts.URL, "http", "ws", 1)
+}
+
+func testEchoHandler(ws *websocket.Conn) {
+	io.Copy(ws, ws)
+}
+
+func TestCheckStatusWebSocket(t *testing.T) {
+	url := testWebSocketServer(t, testEchoHandler)
+	testCheckService(t, "websocket", url, nil, successCheck)
+}
+
+func TestCheckStatusWebSocketEcho(t *testing.T) {
+	url := testWebSocketServer(t, testEchoHandler)
+	opts := map[string]interface{}{
+		"send":   "ping 42",
+		"expect": "^ping [0-9]+$",
+	}
+	testCheckService(t, "websocket", url, opts, successCheck)
+}
+
+func TestCheckStatusWebSocketSkipsMessages(t *testing.T) {
+	url := testWebSocketServer(t, func(ws *websocket.Conn) {
+		websocket.Message.Send(ws, "welcome")
+		var msg string
+		websocket.Message.Receive(ws, &msg)
+		websocket.Message.Send(ws, "pong")
+	})
+	opts := map[string]interface{}{
+		"send":   "ping",
+		"expect": "pong",
+	}
+	testCheckService(t, "websocket", url, opts, successCheck)
+}
+
+func TestCheckStatusWebSocketNoMatch(t *testing.T) {
+	url := testWebSocketServer(t, func(ws *websocket.Conn) {
+		websocket.Message.Send(ws, "go away")
+	})
+	opts := map[string]interface{}{
+		"send":   "ping",
+		"expect": "pong",
+	}
+	testCheckService(t, "websocket", url, opts, failCheck)
+}
+
+func TestCheckStatusWebSocketNotUpgraded(t *testing.T) {
+	ts := httptest.NewServer(nil)
+	defer ts.Close()
+	url := strings.Replace(ts.URL, "http", "ws", 1)
+	testCheckService(t, "websocket", url, nil, failCheck)
+}
+
+func TestCheckStatusWebSocketMissing(t *testing.T) {
+	testCheckService(t, "websocket", "ws://127.0.0.1:55555", nil, failCheck)
+}
+
+// A server that accepts the connection but never answers the upgrade
+func TestCheckStatusWebSocketNoHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cfg := []*Config{{
+		Name:    "SiteCheckTest",
+		Type:    "websocket",
+		URL:     []string{"ws://" + l.Addr().String()},
+		state:   []string{"unknown"},
+		Timeout: 1,
+	}}
+
+	s := &server{cfg: cfg}
+
+	start := time.Now()
+	s.refresh(Wait)
+
+	failCheck(t, cfg)
+
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("check took %s", elapsed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ZooKeeper struct{}
+
+type zookeeperOptions struct {
+	MaxOutstanding int `yaml:"max_outstanding"`
+	MaxLatency     int `yaml:"max_latency"`
+}
+
+// Send a four letter word, the server replies and closes the connection
+func zkCommand(addr, word string, deadline time.Time) (string, error) {
+	c, err := net.DialTimeout("tcp", addr, deadline.Sub(time.Now()))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if err := c.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	if _, err := c.Write([]byte(word)); err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Parse "key: value" lines from srvr, or tab separated mntr output
+func zkFields(reply string) map[string]string {
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewBufferString(reply))
+	for scanner.Scan() {
+		line := scanner.Text()
+		sep := strings.IndexAny(line, ":\t")
+		if sep < 0 {
+			continue
+		}
+		fields[strings.TrimSpace(line[:sep])] = strings.TrimSpace(line[sep+1:])
+	}
+
+	return fields
+}
+
+// Check a single server, returning its mode
+func (z *ZooKeeper) server(addr string, opts *zookeeperOptions, deadline time.Time) (*URL, string) {
+	u := &URL{Name: addr, State: "offline", URL: addr}
+
+	reply, err := zkCommand(addr, "ruok", deadline)
+	if err != nil {
+		u.Name += " " + err.Error()
+		return u, ""
+	}
+	if reply != "imok" {
+		u.Name += " not ok"
+		return u, ""
+	}
+
+	reply, err = zkCommand(addr, "srvr", deadline)
+	if err != nil {
+		u.Name += " " + err.Error()
+		return u, ""
+	}
+
+	srvr := zkFields(reply)
+	mode := srvr["Mode"]
+	if mode == "" {
+		u.Name += " not serving"
+		return u, ""
+	}
+
+	u.State = "online"
+	u.Name += " " + mode
+
+	// mntr may be missing from the four letter word whitelist, srvr
+	// carries the same figures in a different form
+	outstanding, _ := strconv.Atoi(srvr["Outstanding"])
+	latency := 0
+	if l := strings.Split(srvr["Latency min/avg/max"], "/"); len(l) == 3 {
+		f, _ := strconv.ParseFloat(l[1], 64)
+		latency = int(f)
+	}
+
+	if reply, err := zkCommand(addr, "mntr", deadline); err == nil {
+		mntr := zkFields(reply)
+		if v, ok := mntr["zk_outstanding_requests"]; ok {
+			outstanding, _ = strconv.Atoi(v)
+		}
+		if v, ok := mntr["zk_avg_latency"]; ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			latency = int(f)
+		}
+	}
+
+	u.Name += fmt.Sprintf(" (outstanding %d, latency %dms)", outstanding, latency)
+
+	if opts.MaxOutstanding > 0 && outstanding > opts.MaxOutstanding {
+		u.State = "degraded"
+	}
+	if opts.MaxLatency > 0 && latency > opts.MaxLatency {
+		u.State = "degraded"
+	}
+
+	return u, mode
+}
+
+func (z *ZooKeeper) Check(srv Service) (bool, error) {
+	state, _, err := z.CheckTree(srv)
+	return state == "online", err
+}
+
+// Check each server of the comma separated ensemble, an ensemble without a
+// leader is offline
+func (z *ZooKeeper) CheckTree(srv Service) (string, []*URL, error) {
+	opts := &zookeeperOptions{}
+	if err := srv.decodeOptions(opts); err != nil {
+		return "offline", nil, fmt.Errorf("options: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Duration(srv.Timeout) * time.Second)
+
+	children := make([]*URL, 0)
+	state := "online"
+	leader := false
+
+	for _, addr := range strings.Split(strings.TrimPrefix(srv.URL, "zk://"), ",") {
+		u, mode := z.server(addr, opts, deadline)
+		children = append(children, u)
+
+		if mode == "leader" || mode == "standalone" {
+			leader = true
+		}
+		if u.State != "online" {
+			state = "degraded"
+		}
+	}
+
+	if !leader {
+		return "offline", children, errors.New("no leader")
+	}
+
+	return state, children, nil
+}
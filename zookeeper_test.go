@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Answer four letter words as a server in the given mode, an empty mode
+// behaves like a server that has lost quorum
+func testZooKeeper(t *testing.T, mode string, outstanding int) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			buf := make([]byte, 4)
+			n, _ := conn.Read(buf)
+
+			switch string(buf[:n]) {
+			case "ruok":
+				fmt.Fprint(conn, "imok")
+			case "srvr":
+				if mode == "" {
+					fmt.Fprint(conn, "This ZooKeeper instance is not currently serving requests\n")
+					break
+				}
+				fmt.Fprintf(conn, "Zookeeper version: 3.8.4\nLatency min/avg/max: 0/3/20\nOutstanding: %d\nMode: %s\n", outstanding, mode)
+			case "mntr":
+				fmt.Fprintf(conn, "zk_version\t3.8.4\nzk_avg_latency\t2.5\nzk_outstanding_requests\t%d\n", outstanding)
+			}
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestCheckStatusZooKeeper(t *testing.T) {
+	url := strings.Join([]string{
+		testZooKeeper(t, "leader", 0),
+		testZooKeeper(t, "follower", 0),
+		testZooKeeper(t, "follower", 0),
+	}, ",")
+	testCheckService(t, "zookeeper", url, nil, func(t *testing.T, cfg []*Config) {
+		successCheck(t, cfg)
+		children := cfg[0].children[0]
+		if len(children) != 3 {
+			t.Fatalf("expected 3 children, got %d", len(children))
+		}
+		if !strings.Contains(children[0].Name, "leader (outstanding 0, latency 2ms)") {
+			t.Errorf("unexpected child %s", children[0].Name)
+		}
+	})
+}
+
+func TestCheckStatusZooKeeperStandalone(t *testing.T) {
+	testCheckService(t, "zookeeper", "zk://"+testZooKeeper(t, "standalone", 0), nil, successCheck)
+}
+
+func TestCheckStatusZooKeeperNoLeader(t *testing.T) {
+	url := strings.Join([]string{
+		testZooKeeper(t, "", 0),
+		testZooKeeper(t, "follower", 0),
+	}, ",")
+	testCheckService(t, "zookeeper", url, nil, failCheck)
+}
+
+func TestCheckStatusZooKeeperMemberDown(t *testing.T) {
+	url := strings.Join([]string{
+		testZooKeeper(t, "leader", 0),
+		testZooKeeper(t, "follower", 0),
+		"127.0.0.1:55555",
+	}, ",")
+	testCheckService(t, "zookeeper", url, nil, degradedCheck)
+}
+
+func TestCheckStatusZooKeeperOutstanding(t *testing.T) {
+	url := testZooKeeper(t, "leader", 50)
+	testCheckService(t, "zookeeper", url, map[string]interface{}{"max_outstanding": 10}, degradedCheck)
+}